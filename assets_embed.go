@@ -0,0 +1,19 @@
+//go:build release
+
+package main
+
+import (
+	"embed"
+
+	"game/scripts"
+)
+
+// Release builds (go build -tags release) carry every asset inside the binary,
+// so the game can be launched from anywhere. Use -assets to override them with a directory on disk.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+func init() {
+	scripts.SetAssetFS(embeddedAssets)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// a 3x5 pixel font, just enough for row/column labels
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", ".##", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	',': {"...", "...", "...", ".#.", "#.."},
+	'r': {"...", "##.", "#.#", "#..", "#.."},
+	'c': {"...", ".##", "#..", "#..", ".##"},
+}
+
+const (
+	glyphW = 3
+	glyphH = 5
+)
+
+// textWidth is the width of s drawn with drawText at the given pixel size.
+func textWidth(s string, px int) int {
+	if s == "" {
+		return 0
+	}
+	return (len(s)*(glyphW+1) - 1) * px
+}
+
+// drawText draws s with its top left at (x, y), each font pixel px by px. Unknown characters are blank.
+func drawText(dst draw.Image, s string, x int, y int, px int, c color.Color) {
+	src := image.NewUniform(c)
+	for i, r := range s {
+		glyph, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		gx := x + i*(glyphW+1)*px
+		for row, line := range glyph {
+			for col, bit := range line {
+				if bit != '#' {
+					continue
+				}
+				rect := image.Rect(gx+col*px, y+row*px, gx+(col+1)*px, y+(row+1)*px)
+				draw.Draw(dst, rect, src, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Command spritesheet writes a labelled contact sheet of a sprite sheet: every frame in a grid, tagged with
+its row and column, so clip rows can be read off instead of guessed.
+
+	go run ./cmd/spritesheet -frame 64 assets/enemies/skeletonspritesheet.png
+	go run ./cmd/spritesheet -manifest assets/characters/wizard/wizard.anim.json -o wizard.png
+
+With -manifest it writes one contact sheet per sheet the manifest uses, outlines each clip's frames in
+its own color (listed on stdout, split between clips sharing a frame), and fails if a clip's frames fall outside its sheet.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"game/model"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	pad     = 4  // around each frame
+	labelPx = 2  // label font pixel size
+	labelH  = 14 // label strip above each frame
+)
+
+var (
+	background = color.RGBA{R: 40, G: 40, B: 48, A: 255}
+	checkLight = color.RGBA{R: 110, G: 110, B: 120, A: 255}
+	checkDark  = color.RGBA{R: 90, G: 90, B: 100, A: 255}
+	emptyCell  = color.RGBA{R: 60, G: 30, B: 30, A: 255}
+	labelColor = color.RGBA{R: 230, G: 230, B: 230, A: 255}
+)
+
+// clipColor is the outline color of the i'th of n clips on a sheet, hues spread evenly so every clip
+// gets its own.
+func clipColor(i int, n int) color.RGBA {
+	h := float64(i) / float64(n) * 6
+	x := uint8(255 * (1 - math.Abs(math.Mod(h, 2)-1)))
+	switch int(h) {
+	case 0:
+		return color.RGBA{R: 255, G: x, A: 255}
+	case 1:
+		return color.RGBA{R: x, G: 255, A: 255}
+	case 2:
+		return color.RGBA{G: 255, B: x, A: 255}
+	case 3:
+		return color.RGBA{G: x, B: 255, A: 255}
+	case 4:
+		return color.RGBA{R: x, B: 255, A: 255}
+	}
+	return color.RGBA{R: 255, B: x, A: 255}
+}
+
+// cellClip marks a frame cell as part of one or more clips, e.g. block and thrust sharing rows
+type cellClip struct {
+	colors []color.RGBA
+}
+
+func main() {
+	frameSize := flag.Int("frame", 64, "frame size in pixels (frames are square)")
+	manifestPath := flag.String("manifest", "", "animation manifest (.anim.json) to read sheets and clips from")
+	out := flag.String("o", "", "output PNG (default <sheet>_contact.png in the working directory)")
+	scale := flag.Int("scale", 1, "scale frames up by this much")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: spritesheet [-frame N] [-scale N] [-o out.png] <sheet.png>")
+		fmt.Fprintln(os.Stderr, "       spritesheet -manifest <character.anim.json> [-scale N] [-o out.png]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *scale < 1 {
+		log.Fatal("-scale must be at least 1")
+	}
+
+	if *manifestPath != "" {
+		if !contactManifest(*manifestPath, *out, *scale) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sheetPath := flag.Arg(0)
+	sheet, err := readPNG(sheetPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	size := sheet.Bounds().Size()
+	if size.X%*frameSize != 0 || size.Y%*frameSize != 0 {
+		fmt.Printf("warning: %dx%d isn't a multiple of the %dpx frame size, the last row/column is cut off\n", size.X, size.Y, *frameSize)
+	}
+	dst := outputPath(*out, sheetPath, false)
+	if err := writeContactSheet(dst, sheet, *frameSize, *scale, nil); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d rows x %d columns of %dpx frames -> %s\n", sheetPath, size.Y / *frameSize, size.X / *frameSize, *frameSize, dst)
+}
+
+// contactManifest writes a contact sheet for each sheet of the manifest and checks its clips. Reports whether every clip fits.
+func contactManifest(manifestPath string, out string, scale int) bool {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := model.ParseAnimationManifest(data, filepath.ToSlash(filepath.Dir(manifestPath)))
+	if err != nil {
+		log.Fatalf("%s: %v", manifestPath, err)
+	}
+
+	// group clips by sheet, each sheet gets one contact sheet
+	type sheetClips struct {
+		frameSize int
+		clips     []string
+	}
+	sheets := make(map[string]*sheetClips)
+	var sheetOrder []string
+	for _, name := range m.ClipNames() {
+		clip := m.Clips[name]
+		path := m.SheetOf(clip)
+		sc, ok := sheets[path]
+		if !ok {
+			sc = &sheetClips{frameSize: m.FrameSizeOf(clip)}
+			sheets[path] = sc
+			sheetOrder = append(sheetOrder, path)
+		}
+		sc.clips = append(sc.clips, name)
+	}
+	sort.Strings(sheetOrder)
+
+	ok := true
+	for _, path := range sheetOrder {
+		sc := sheets[path]
+		sheet, err := readPNG(filepath.FromSlash(path))
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			ok = false
+			continue
+		}
+
+		fmt.Printf("%s (%dx%d, %dpx frames)\n", path, sheet.Bounds().Dx(), sheet.Bounds().Dy(), sc.frameSize)
+		cells := make(map[image.Point]cellClip)
+		for i, name := range sc.clips {
+			clip := m.Clips[name]
+			outline := clipColor(i, len(sc.clips))
+			if m.FrameSizeOf(clip) != sc.frameSize {
+				fmt.Printf("  %-16s frame size %d differs from the sheet's other clips (%d), not outlined\n", name, m.FrameSizeOf(clip), sc.frameSize)
+			}
+			if err := m.CheckBounds(name, sheet.Bounds().Size()); err != nil {
+				fmt.Printf("  %-16s FAIL %v\n", name, err)
+				ok = false
+				continue
+			}
+			var rows []string
+			for _, dir := range clip.ClipDirections() {
+				row, startCol := clip.RowFor(dir)
+				if dir == "" {
+					dir = "all"
+				}
+				rows = append(rows, fmt.Sprintf("%s r%d c%d-%d", dir, row, startCol, startCol+clip.Frames-1))
+				if m.FrameSizeOf(clip) != sc.frameSize {
+					continue
+				}
+				for col := startCol; col < startCol+clip.Frames; col++ {
+					cc := cells[image.Pt(col, row)]
+					cc.colors = append(cc.colors, outline)
+					cells[image.Pt(col, row)] = cc
+				}
+			}
+			fmt.Printf("  %-16s #%02x%02x%02x %s\n", name, outline.R, outline.G, outline.B, strings.Join(rows, ", "))
+		}
+
+		dst := outputPath(out, path, len(sheetOrder) > 1)
+		if err := writeContactSheet(dst, sheet, sc.frameSize, scale, cells); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("  -> %s\n", dst)
+	}
+	return ok
+}
+
+// outputPath is out, or <sheet>_contact.png when out is empty. When a manifest has several sheets,
+// each gets out's name with the sheet's name appended.
+func outputPath(out string, sheetPath string, perSheet bool) string {
+	sheetName := strings.TrimSuffix(filepath.Base(sheetPath), filepath.Ext(sheetPath))
+	if out == "" {
+		return sheetName + "_contact.png"
+	}
+	if perSheet {
+		return strings.TrimSuffix(out, filepath.Ext(out)) + "_" + sheetName + ".png"
+	}
+	return out
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
+
+// writeContactSheet lays out every frame of sheet in a grid with its "r,c" label above it.
+// Frames in cells are outlined in their clip's color, split into a band per clip when clips share the
+// frame. Fully transparent frames get a red tint.
+func writeContactSheet(path string, sheet image.Image, frameSize int, scale int, cells map[image.Point]cellClip) error {
+	rows := sheet.Bounds().Dy() / frameSize
+	cols := sheet.Bounds().Dx() / frameSize
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("sheet is smaller than one %dpx frame", frameSize)
+	}
+
+	// each cell is wide enough for the frame and its widest label
+	frameW := frameSize * scale
+	cellW := max(frameW, textWidth(fmt.Sprintf("r%d,c%d", rows-1, cols-1), labelPx)) + 2*pad
+	cellH := labelH + frameW + 2*pad
+
+	dst := image.NewRGBA(image.Rect(0, 0, cols*cellW, rows*cellH))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			src := image.Rect(col*frameSize, row*frameSize, (col+1)*frameSize, (row+1)*frameSize).Add(sheet.Bounds().Min)
+			x := col*cellW + (cellW-frameW)/2
+			y := row*cellH + labelH + pad
+			frame := image.Rect(x, y, x+frameW, y+frameW)
+
+			if cc, ok := cells[image.Pt(col, row)]; ok {
+				outline := frame.Inset(-2)
+				for i, c := range cc.colors {
+					band := outline
+					band.Min.X = outline.Min.X + outline.Dx()*i/len(cc.colors)
+					band.Max.X = outline.Min.X + outline.Dx()*(i+1)/len(cc.colors)
+					draw.Draw(dst, band, image.NewUniform(c), image.Point{}, draw.Src)
+				}
+			}
+			if isEmpty(sheet, src) {
+				draw.Draw(dst, frame, image.NewUniform(emptyCell), image.Point{}, draw.Src)
+			} else {
+				drawChecker(dst, frame, 8)
+			}
+			drawScaled(dst, frame.Min, sheet, src, scale)
+
+			drawText(dst, fmt.Sprintf("r%d,c%d", row, col), col*cellW+pad, row*cellH+pad, labelPx, labelColor)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, dst); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func isEmpty(img image.Image, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// drawChecker fills r with a checkerboard, so transparent pixels are easy to tell apart
+func drawChecker(dst draw.Image, r image.Rectangle, size int) {
+	for y := r.Min.Y; y < r.Max.Y; y += size {
+		for x := r.Min.X; x < r.Max.X; x += size {
+			c := checkLight
+			if ((x-r.Min.X)/size+(y-r.Min.Y)/size)%2 == 1 {
+				c = checkDark
+			}
+			square := image.Rect(x, y, x+size, y+size).Intersect(r)
+			draw.Draw(dst, square, image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// drawScaled draws the src region of img at at, scaled up with nearest neighbour
+func drawScaled(dst draw.Image, at image.Point, img image.Image, src image.Rectangle, scale int) {
+	if scale == 1 {
+		draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(src.Size())}, img, src.Min, draw.Over)
+		return
+	}
+	for y := src.Min.Y; y < src.Max.Y; y++ {
+		for x := src.Min.X; x < src.Max.X; x++ {
+			px := image.NewUniform(img.At(x, y))
+			p := at.Add(image.Pt((x-src.Min.X)*scale, (y-src.Min.Y)*scale))
+			draw.Draw(dst, image.Rect(p.X, p.Y, p.X+scale, p.Y+scale), px, image.Point{}, draw.Over)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+/*
+Command validateassets checks every asset the game references: the toolbar sheets it loads by path, every
+animation manifest (sheets exist, clips inside their sheets), every Tiled map and arena generator (tilesets
+resolve, every tile it uses has an image), every particle effect (parses, image exists) and every LPC character.json (layer
+sheets, exported sheet sizes).
+
+	go run ./cmd/validateassets
+	go run ./cmd/validateassets -assets path/to/game -v
+
+It exits with status 1 if anything the game needs is missing or broken. Warnings (e.g. LPC layers the
+game falls back from) don't fail it.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"game/model"
+	"image"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+
+	_ "image/png"
+)
+
+type report struct {
+	fsys     fs.FS
+	verbose  bool
+	errors   int
+	warnings int
+	sizes    map[string]image.Point // decoded image sizes, by path
+}
+
+func (r *report) errorf(path string, format string, args ...any) {
+	r.errors++
+	fmt.Printf("ERROR %s: %s\n", path, fmt.Sprintf(format, args...))
+}
+
+func (r *report) warnf(path string, format string, args ...any) {
+	r.warnings++
+	fmt.Printf("WARN  %s: %s\n", path, fmt.Sprintf(format, args...))
+}
+
+func (r *report) okf(path string, format string, args ...any) {
+	if r.verbose {
+		fmt.Printf("ok    %s: %s\n", path, fmt.Sprintf(format, args...))
+	}
+}
+
+func main() {
+	root := flag.String("assets", ".", "directory containing assets/ (the repo root)")
+	verbose := flag.Bool("v", false, "list every asset checked, not just problems")
+	flag.Parse()
+
+	r := &report{
+		fsys:    os.DirFS(*root),
+		verbose: *verbose,
+		sizes:   make(map[string]image.Point),
+	}
+	if _, err := fs.Stat(r.fsys, "assets"); err != nil {
+		fmt.Fprintf(os.Stderr, "no assets directory in %q, run from the repo root or pass -assets\n", *root)
+		os.Exit(2)
+	}
+
+	r.checkImageRefs()
+	r.checkManifests()
+	r.checkMaps()
+	r.checkEffects()
+	r.checkCharacters()
+
+	fmt.Printf("%d errors, %d warnings\n", r.errors, r.warnings)
+	if r.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// imageSize returns the size of the image at path, decoding only its header.
+func (r *report) imageSize(path string) (image.Point, error) {
+	if size, ok := r.sizes[path]; ok {
+		return size, nil
+	}
+	f, err := r.fsys.Open(path)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("not a PNG image: %w", err)
+	}
+	size := image.Pt(cfg.Width, cfg.Height)
+	r.sizes[path] = size
+	return size, nil
+}
+
+func describe(err error) string {
+	if errors.Is(err, fs.ErrNotExist) {
+		return "missing"
+	}
+	return err.Error()
+}
+
+func (r *report) checkImageRefs() {
+	for _, ref := range model.GameImageRefs() {
+		size, err := r.imageSize(ref.Path)
+		if err != nil {
+			r.errorf(ref.Path, "%s (%s)", describe(err), ref.Usage)
+			continue
+		}
+		if size.X < ref.MinSize.X || size.Y < ref.MinSize.Y {
+			r.errorf(ref.Path, "%dx%d, the %s needs at least %dx%d", size.X, size.Y, ref.Usage, ref.MinSize.X, ref.MinSize.Y)
+			continue
+		}
+		r.okf(ref.Path, "%dx%d (%s)", size.X, size.Y, ref.Usage)
+	}
+}
+
+// walk returns every file under assets/ whose name ends in suffix, sorted.
+func (r *report) walk(suffix string) []string {
+	var paths []string
+	fs.WalkDir(r.fsys, "assets", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, suffix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths
+}
+
+func (r *report) checkManifests() {
+	found := make(map[string]bool)
+	for _, path := range r.walk(".anim.json") {
+		found[path] = true
+		r.checkManifest(path)
+	}
+	for _, path := range model.GameManifests() {
+		if !found[path] {
+			r.errorf(path, "missing, the game loads it at startup")
+		}
+	}
+}
+
+func (r *report) checkManifest(path string) {
+	data, err := fs.ReadFile(r.fsys, path)
+	if err != nil {
+		r.errorf(path, "%s", describe(err))
+		return
+	}
+	m, err := model.ParseAnimationManifest(data, pathpkg.Dir(path))
+	if err != nil {
+		r.errorf(path, "%v", err)
+		return
+	}
+	for _, name := range m.ClipNames() {
+		clip := m.Clips[name]
+		sheet := m.SheetOf(clip)
+		size, err := r.imageSize(sheet)
+		if err != nil {
+			r.errorf(path, "clip %q: sheet %s: %s", name, sheet, describe(err))
+			continue
+		}
+		if err := m.CheckBounds(name, size); err != nil {
+			r.errorf(path, "%v (%s)", err, sheet)
+			continue
+		}
+		r.okf(path, "clip %q in %s", name, sheet)
+	}
+}
+
+func (r *report) checkMaps() {
+	found := false
+	for _, path := range r.walk("") {
+		if !strings.HasPrefix(path, model.MapsDir+"/") || !isMapFile(path) {
+			continue
+		}
+		found = found || path == model.DefaultMap
+		r.checkMap(path)
+	}
+	if !found {
+		r.errorf(model.DefaultMap, "missing, the game loads it at startup")
+	}
+}
+
+func isMapFile(path string) bool {
+	switch pathpkg.Ext(path) {
+	case ".tmj", ".tmx":
+		return true
+	}
+	return model.IsArenaGen(path)
+}
+
+// loadMap loads a Tiled map, or generates an arena with a fixed seed.
+func (r *report) loadMap(path string, data []byte) (*model.TiledMap, error) {
+	read := func(p string) ([]byte, error) { return fs.ReadFile(r.fsys, p) }
+	if model.IsArenaGen(path) {
+		gen, err := model.ParseArenaGen(data, pathpkg.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		return gen.Generate(1, read)
+	}
+	m, err := model.ParseTiledMap(data, pathpkg.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.ResolveTilesets(read); err != nil {
+		return nil, err
+	}
+	if err := m.AutotileLayers(read); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkMap checks every tile a map places, in tile layers and as objects, has an image to cut it from.
+func (r *report) checkMap(path string) {
+	data, err := fs.ReadFile(r.fsys, path)
+	if err != nil {
+		r.errorf(path, "%s", describe(err))
+		return
+	}
+	m, err := r.loadMap(path, data)
+	if err != nil {
+		r.errorf(path, "%v", err)
+		return
+	}
+
+	used := make(map[uint32]bool)
+	for _, layer := range m.Layers {
+		if layer.IsCollision() {
+			continue
+		}
+		for _, gid := range layer.Tiles {
+			used[gid] = true
+		}
+		for _, obj := range layer.Objects {
+			used[obj.GID] = true
+		}
+	}
+	delete(used, 0)
+	bad := 0
+	for gid := range used {
+		ts, id := m.TilesetFor(gid)
+		if ts == nil {
+			r.errorf(path, "tile %d isn't in any tileset", gid)
+			bad++
+			continue
+		}
+		img := ts.ImageOf(id)
+		size, err := r.imageSize(img)
+		if err != nil {
+			r.errorf(path, "tile %d of %q: %s %s", id, ts.Name, img, describe(err))
+			bad++
+			continue
+		}
+		if ts.Image != "" && !ts.TileRect(id).In(image.Rectangle{Max: size}) {
+			r.errorf(path, "tile %d of %q is outside %s (%dx%d)", id, ts.Name, img, size.X, size.Y)
+			bad++
+		}
+	}
+
+	spawns := 0
+	for _, layer := range m.Layers {
+		for _, obj := range layer.Objects {
+			if obj.Class == "spawn" && obj.Name == "player" {
+				spawns++
+			}
+		}
+	}
+	if spawns == 0 {
+		r.warnf(path, "no spawn object called \"player\", the player starts in the top left")
+	}
+	if bad == 0 {
+		r.okf(path, "%dx%d tiles, %d layers, %d distinct tiles", m.Width, m.Height, len(m.Layers), len(used))
+	}
+}
+
+func (r *report) checkEffects() {
+	for _, path := range r.walk(".json") {
+		if pathpkg.Dir(path) != model.EffectsDir {
+			continue
+		}
+		data, err := fs.ReadFile(r.fsys, path)
+		if err != nil {
+			r.errorf(path, "%s", describe(err))
+			continue
+		}
+		var effect struct {
+			Image string `json:"image"`
+		}
+		if err := json.Unmarshal(data, &effect); err != nil {
+			r.errorf(path, "%v", err)
+			continue
+		}
+		if effect.Image == "" {
+			r.warnf(path, "no image, it can't be spawned by name")
+			continue
+		}
+		if _, err := r.imageSize(effect.Image); err != nil {
+			r.errorf(path, "image %s: %s", effect.Image, describe(err))
+			continue
+		}
+		r.okf(path, "image %s", effect.Image)
+	}
+}
+
+func (r *report) checkCharacters() {
+	for _, path := range r.walk("/character.json") {
+		r.checkCharacter(path)
+	}
+}
+
+// checkCharacter checks an LPC character's exported sheets are the size of their animation, and which
+// layer sheets are missing. Missing layers only warn, the game uses the exported sheet instead.
+func (r *report) checkCharacter(path string) {
+	data, err := fs.ReadFile(r.fsys, path)
+	if err != nil {
+		r.errorf(path, "%s", describe(err))
+		return
+	}
+	c, err := model.ParseLPCCharacter(data)
+	if err != nil {
+		r.errorf(path, "%v", err)
+		return
+	}
+	dir := pathpkg.Dir(path)
+	layersDir := pathpkg.Join(dir, "layers")
+
+	// exported sheets, needed for every animation with a missing layer
+	exported := make(map[string]bool)
+	for i := range model.LPCAnimations {
+		anim := &model.LPCAnimations[i]
+		want := image.Pt(anim.Frames*model.LPCFrameSize, anim.Rows*model.LPCFrameSize)
+		exported[anim.Name] = r.checkExported(pathpkg.Join(dir, anim.StandardSheet()), want)
+	}
+	for i := range model.LPCCustomAnimations {
+		custom := &model.LPCCustomAnimations[i]
+		if !usesCustom(c, custom.Name) {
+			continue
+		}
+		base := model.LPCAnimationNamed(custom.Base)
+		want := image.Pt(base.Frames*custom.FrameSize, base.Rows*custom.FrameSize)
+		exported[custom.Name] = r.checkExported(pathpkg.Join(dir, custom.CustomSheet()), want)
+	}
+
+	var warnings []string
+	layers := c.SortedLayers()
+	for _, layer := range layers {
+		var missing, unbacked []string
+		for i := range model.LPCAnimations {
+			anim := &model.LPCAnimations[i]
+			name := anim.Name
+			if layer.CustomAnimation != "" {
+				if i > 0 {
+					break // one sheet for the whole custom animation
+				}
+				name = layer.CustomAnimation
+			} else if !layer.Supports(anim) {
+				continue
+			}
+			if _, err := fs.Stat(r.fsys, pathpkg.Join(layersDir, layer.SheetFor(anim))); err == nil {
+				continue
+			}
+			missing = append(missing, name)
+			if !exported[name] {
+				unbacked = append(unbacked, name)
+			}
+		}
+		switch {
+		case len(unbacked) > 0:
+			r.errorf(path, "layer %s (%s): no layer sheet and no exported sheet for %s", layer.ParentName, layer.FileName, strings.Join(unbacked, ", "))
+		case len(missing) > 0:
+			warnings = append(warnings, fmt.Sprintf("layer %s (%s): no sheet in %s for %d animations, using the exported sheets", layer.ParentName, layer.FileName, layersDir, len(missing)))
+		default:
+			r.okf(path, "layer %s (%s)", layer.ParentName, layer.FileName)
+		}
+	}
+
+	// no layers at all is the usual case (just the generator's export), one warning will do
+	if len(warnings) == len(layers) && !r.verbose {
+		r.warnf(path, "none of the %d layer sheets are in %s, using the exported sheets (-v lists them)", len(layers), layersDir)
+		return
+	}
+	for _, w := range warnings {
+		r.warnf(path, "%s", w)
+	}
+}
+
+// checkExported reports whether an exported sheet exists at the right size. A missing one is fine
+// as long as its layers are all there, checkCharacter reports it otherwise.
+func (r *report) checkExported(path string, want image.Point) bool {
+	size, err := r.imageSize(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			r.errorf(path, "%v", err)
+		}
+		return false
+	}
+	if size != want {
+		r.errorf(path, "%dx%d, expected %dx%d", size.X, size.Y, want.X, want.Y)
+		return false
+	}
+	r.okf(path, "%dx%d", size.X, size.Y)
+	return true
+}
+
+func usesCustom(c *model.LPCCharacter, name string) bool {
+	for _, layer := range c.Layers {
+		if layer.CustomAnimation == name {
+			return true
+		}
+	}
+	return false
+}
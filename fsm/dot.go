@@ -0,0 +1,98 @@
+package fsm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Path is s's ID prefixed with its ancestors', e.g. "walk_down/frame9".
+func (s *State[T]) Path() string {
+	ids := []string{s.ID}
+	for _, a := range s.Ancestors() {
+		ids = append(ids, a.ID)
+	}
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return strings.Join(ids, "/")
+}
+
+// WriteDOT writes every state reachable from start as a Graphviz digraph named name.
+// Parent states become clusters holding their children, and their transitions are drawn from the cluster's edge.
+// Guarded transitions are dashed, and current (if not nil) is filled in.
+func WriteDOT[T any](w io.Writer, name string, start *State[T], current *State[T]) error {
+	states := Reachable(start)
+
+	ids := make(map[*State[T]]string, len(states))
+	children := make(map[*State[T]][]*State[T])
+	var roots []*State[T]
+	for i, s := range states {
+		ids[s] = fmt.Sprintf("s%d", i)
+		if s.Parent != nil {
+			children[s.Parent] = append(children[s.Parent], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+
+	// a cluster has no node of its own, edges to and from it use its first leaf with lhead/ltail
+	anchor := func(s *State[T]) *State[T] {
+		for len(children[s]) > 0 {
+			s = children[s][0]
+		}
+		return s
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %q {\n", name)
+	fmt.Fprintln(bw, "\tcompound=true;")
+	fmt.Fprintln(bw, "\tnode [shape=box, fontsize=10];")
+	fmt.Fprintln(bw, "\tedge [fontsize=9];")
+
+	var writeState func(s *State[T], indent string)
+	writeState = func(s *State[T], indent string) {
+		if kids := children[s]; len(kids) > 0 {
+			fmt.Fprintf(bw, "%ssubgraph cluster_%s {\n", indent, ids[s])
+			fmt.Fprintf(bw, "%s\tlabel=%q;\n", indent, s.ID)
+			for _, kid := range kids {
+				writeState(kid, indent+"\t")
+			}
+			fmt.Fprintf(bw, "%s}\n", indent)
+			return
+		}
+		attrs := fmt.Sprintf("label=%q", s.ID)
+		if s == current {
+			attrs += ", style=filled, fillcolor=gold"
+		}
+		fmt.Fprintf(bw, "%s%s [%s];\n", indent, ids[s], attrs)
+	}
+	for _, s := range roots {
+		writeState(s, "\t")
+	}
+
+	for _, s := range states {
+		for _, t := range s.transitions {
+			if t.To == nil {
+				continue
+			}
+			attrs := []string{fmt.Sprintf("label=%q", string(t.Input))}
+			if t.Input == Auto {
+				attrs[0] = `label="(auto)"`
+			}
+			if t.Guard != nil {
+				attrs = append(attrs, "style=dashed")
+			}
+			if len(children[s]) > 0 {
+				attrs = append(attrs, "ltail=cluster_"+ids[s])
+			}
+			if len(children[t.To]) > 0 {
+				attrs = append(attrs, "lhead=cluster_"+ids[t.To])
+			}
+			fmt.Fprintf(bw, "\t%s -> %s [%s];\n", ids[anchor(s)], ids[anchor(t.To)], strings.Join(attrs, ", "))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
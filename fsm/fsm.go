@@ -0,0 +1,176 @@
+/*
+Package fsm is a small typed state machine library.
+
+States carry typed data (an animation frame, an AI mode...), are linked by named inputs, and can be
+nested: a state without a transition for an input defers to its parent, so a group of states (e.g. every
+frame of a walk cycle) can share transitions declared once on the parent. Transitions may be guarded,
+and a Machine runs enter/exit/update callbacks as it moves between states.
+*/
+package fsm
+
+// Input names a transition.
+type Input string
+
+const (
+	// Auto transitions are taken by Machine.Update as soon as their guard passes.
+	Auto Input = ""
+	Next Input = "next"
+	Prev Input = "prev"
+)
+
+type Transition[T any] struct {
+	Input Input
+	To    *State[T]
+	Guard func() bool // nil means always allowed
+}
+
+type State[T any] struct {
+	ID     string
+	Data   T
+	Parent *State[T]
+
+	OnEnter  func(from *State[T], input Input)
+	OnExit   func(to *State[T], input Input)
+	OnUpdate func(dt float32)
+
+	transitions []*Transition[T] // in the order they were added, the first allowed one wins
+}
+
+func NewState[T any](id string, data T) *State[T] {
+	return &State[T]{
+		ID:   id,
+		Data: data,
+	}
+}
+
+// AddTransition links s to next on input, replacing any unguarded transition s already had for that input.
+// Returns next so chains can be built fluently.
+func (s *State[T]) AddTransition(input Input, next *State[T]) *State[T] {
+	for _, t := range s.transitions {
+		if t.Input == input && t.Guard == nil {
+			t.To = next
+			return next
+		}
+	}
+	s.transitions = append(s.transitions, &Transition[T]{Input: input, To: next})
+	return next
+}
+
+// AddGuardedTransition links s to next on input, only while guard returns true.
+// Guarded transitions are tried in the order they were added.
+func (s *State[T]) AddGuardedTransition(input Input, next *State[T], guard func() bool) *State[T] {
+	s.transitions = append(s.transitions, &Transition[T]{Input: input, To: next, Guard: guard})
+	return next
+}
+
+func (s *State[T]) AddNext(next *State[T]) *State[T] {
+	s.AddTransition(Next, next)
+	return s
+}
+
+func (s *State[T]) AddPrev(prev *State[T]) *State[T] {
+	s.AddTransition(Prev, prev)
+	return s
+}
+
+// SetParent nests s inside parent, so s inherits the parent's transitions.
+func (s *State[T]) SetParent(parent *State[T]) *State[T] {
+	s.Parent = parent
+	return s
+}
+
+// Target is the state input leads to from s, checking s first and then its ancestors. Nil if there is none.
+func (s *State[T]) Target(input Input) *State[T] {
+	for it := s; it != nil; it = it.Parent {
+		for _, t := range it.transitions {
+			if t.Input == input && (t.Guard == nil || t.Guard()) {
+				return t.To
+			}
+		}
+	}
+	return nil
+}
+
+func (s *State[T]) Next() *State[T] {
+	return s.Target(Next)
+}
+
+func (s *State[T]) Prev() *State[T] {
+	return s.Target(Prev)
+}
+
+// Transitions returns the transitions declared on s itself (not its ancestors).
+func (s *State[T]) Transitions() []*Transition[T] {
+	return append([]*Transition[T](nil), s.transitions...)
+}
+
+// Inputs returns every input s responds to, including inherited ones.
+func (s *State[T]) Inputs() []Input {
+	seen := make(map[Input]bool)
+	var inputs []Input
+	for it := s; it != nil; it = it.Parent {
+		for _, t := range it.transitions {
+			if !seen[t.Input] {
+				seen[t.Input] = true
+				inputs = append(inputs, t.Input)
+			}
+		}
+	}
+	return inputs
+}
+
+// Ancestors returns s's parents, innermost first.
+func (s *State[T]) Ancestors() []*State[T] {
+	var ancestors []*State[T]
+	for it := s.Parent; it != nil; it = it.Parent {
+		ancestors = append(ancestors, it)
+	}
+	return ancestors
+}
+
+// IsIn reports whether s is other or nested (at any depth) inside it.
+func (s *State[T]) IsIn(other *State[T]) bool {
+	for it := s; it != nil; it = it.Parent {
+		if it == other {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnectAll adds a transition on input to target from every state reachable from s by Next links
+// (e.g. every frame of a looping clip), stopping when the chain loops or ends.
+func (s *State[T]) ConnectAll(target *State[T], input Input) {
+	for it := s; it != nil; {
+		it.AddTransition(input, target)
+		next := it.Next()
+		if next == s || next == it {
+			break
+		}
+		it = next
+	}
+}
+
+// Reachable returns every state reachable from start through any transition (own or inherited),
+// in breadth first order. Parents are included as they're discovered.
+func Reachable[T any](start *State[T]) []*State[T] {
+	seen := map[*State[T]]bool{start: true}
+	queue := []*State[T]{start}
+	for i := 0; i < len(queue); i++ {
+		s := queue[i]
+		neighbours := []*State[T]{}
+		for _, t := range s.transitions {
+			neighbours = append(neighbours, t.To)
+		}
+		if s.Parent != nil {
+			neighbours = append(neighbours, s.Parent)
+		}
+		for _, n := range neighbours {
+			if n != nil && !seen[n] {
+				seen[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return queue
+}
@@ -0,0 +1,88 @@
+package fsm
+
+// maxAutoSteps stops a cycle of always-true Auto transitions from hanging Update
+const maxAutoSteps = 16
+
+// Machine tracks the current state and runs the enter/exit/update callbacks.
+type Machine[T any] struct {
+	current   *State[T]
+	lastInput Input
+
+	// OnTransition is called after every state change, once exits and enters have run
+	OnTransition func(from *State[T], to *State[T], input Input)
+}
+
+// NewMachine starts in initial, entering it (and its ancestors) right away.
+func NewMachine[T any](initial *State[T]) *Machine[T] {
+	m := &Machine[T]{}
+	m.SetState(initial, Auto)
+	return m
+}
+
+func (m *Machine[T]) Current() *State[T] {
+	return m.current
+}
+
+// LastInput is the input of the most recent transition.
+func (m *Machine[T]) LastInput() Input {
+	return m.lastInput
+}
+
+// Send follows input from the current state. It returns false, and stays put, if nothing handles the input
+// or the target is the current state.
+func (m *Machine[T]) Send(input Input) bool {
+	if m.current == nil {
+		return false
+	}
+	next := m.current.Target(input)
+	if next == nil || next == m.current {
+		return false
+	}
+	m.SetState(next, input)
+	return true
+}
+
+// SetState moves to next regardless of transitions, running exits up to the common ancestor
+// and enters down to next.
+func (m *Machine[T]) SetState(next *State[T], input Input) {
+	from := m.current
+
+	// exit from the innermost state up to (but not including) the common ancestor
+	for it := from; it != nil && !next.IsIn(it); it = it.Parent {
+		if it.OnExit != nil {
+			it.OnExit(next, input)
+		}
+	}
+
+	// enter from the outermost new state down to next
+	var entering []*State[T]
+	for it := next; it != nil && (from == nil || !from.IsIn(it)); it = it.Parent {
+		entering = append(entering, it)
+	}
+	for i := len(entering) - 1; i >= 0; i-- {
+		if entering[i].OnEnter != nil {
+			entering[i].OnEnter(from, input)
+		}
+	}
+
+	m.current = next
+	m.lastInput = input
+	if m.OnTransition != nil && from != nil {
+		m.OnTransition(from, next, input)
+	}
+}
+
+// Update takes any Auto transitions whose guards pass, then runs OnUpdate for the current state
+// and its ancestors (innermost first).
+func (m *Machine[T]) Update(dt float32) {
+	if m.current == nil {
+		return
+	}
+	for i := 0; i < maxAutoSteps && m.Send(Auto); i++ {
+	}
+	for it := m.current; it != nil; it = it.Parent {
+		if it.OnUpdate != nil {
+			it.OnUpdate(dt)
+		}
+	}
+}
@@ -1,9 +1,32 @@
 package main
 
 import (
+	"flag"
+
 	"game/scripts"
 )
 
 func main() {
+	assetDir := flag.String("assets", "", "read assets from this directory instead of the embedded/working-directory ones")
+	dev := flag.Bool("dev", false, "hot reload sprites, tiles and shaders when their files change")
+	hero := flag.String("hero", "", "play as the character in assets/characters/<name>, e.g. wizard")
+	mapFile := flag.String("map", "", "play on this Tiled map (.tmj or .tmx) or arena generator (.gen.json) instead of assets/maps/arena.tmj")
+	seed := flag.Int64("seed", 0, "seed for generated arenas, 0 for a new arena every game")
+	skeletons := flag.Int("skeletons", 5, "number of skeletons to spawn, past 40 they path as a horde down one flow field")
+	flag.Parse()
+
+	scripts.DevMode = *dev
+
+	if *assetDir != "" {
+		scripts.SetAssetDir(*assetDir)
+	}
+	if *hero != "" {
+		scripts.SetHero(*hero)
+	}
+	if *mapFile != "" {
+		scripts.SetMap(*mapFile)
+	}
+	scripts.SetMapSeed(*seed)
+	scripts.SetSkeletonCount(*skeletons)
 	scripts.StartGame()
 }
@@ -0,0 +1,281 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"path"
+	"sort"
+)
+
+// AnimationManifest describes how a character's sprite sheet(s) are laid out:
+// the frame size and the named clips (walk, strife, block...) with their rows and columns.
+type AnimationManifest struct {
+	Sheet     string                    `json:"sheet"`     // default sheet for clips, relative to the manifest
+	FrameSize int                       `json:"frameSize"` // frames are square
+	Base      string                    `json:"base"`      // locomotion clip the others are entered from (default "walk")
+	Idle      string                    `json:"idle"`      // optional clip shown instead of the base clip when standing still
+	Clips     map[string]*AnimationClip `json:"clips"`
+
+	// Body layers clips can play on, e.g. {"upper": {"priority": 1, "top": 0, "bottom": 40}}.
+	// A clip on a layer is drawn over the base clip in the layer's band only, so the legs keep walking.
+	Layers map[string]*AnimationLayer `json:"layers,omitempty"`
+
+	Dir string `json:"-"` // directory the manifest was loaded from
+}
+
+// Directions4 are the facings of a standard LPC sheet, in row order.
+var Directions4 = []string{"up", "left", "down", "right"}
+
+// Directions8 adds the diagonals, for sheets that have rows for them.
+var Directions8 = []string{"up", "up_left", "left", "down_left", "down", "down_right", "right", "up_right"}
+
+var directionAngles = map[string]float64{
+	"right":      0,
+	"down_right": math.Pi / 4,
+	"down":       math.Pi / 2,
+	"down_left":  3 * math.Pi / 4,
+	"left":       math.Pi,
+	"up_left":    -3 * math.Pi / 4,
+	"up":         -math.Pi / 2,
+	"up_right":   -math.Pi / 4,
+}
+
+// DirectionAngle is the facing's angle in radians, clockwise from right since screen y points down.
+func DirectionAngle(dir string) (float64, bool) {
+	a, ok := directionAngles[dir]
+	return a, ok
+}
+
+// CardinalOf is the facing used for a diagonal on sheets without it. Like LPC's own diagonal movement,
+// the horizontal facing wins.
+func CardinalOf(dir string) string {
+	switch dir {
+	case "up_left", "down_left":
+		return "left"
+	case "up_right", "down_right":
+		return "right"
+	}
+	return dir
+}
+
+type LoopMode string
+
+const (
+	LoopModeLoop     LoopMode = "loop"      // wrap back to the first frame
+	LoopModeOnce     LoopMode = "once"      // play through once, then end the clip
+	LoopModePingPong LoopMode = "ping_pong" // play forward then backward, repeatedly
+	LoopModeHoldLast LoopMode = "hold_last" // stay on the last frame
+)
+
+type AnimationClip struct {
+	Sheet     string   `json:"sheet,omitempty"`     // overrides the manifest's sheet
+	FrameSize int      `json:"frameSize,omitempty"` // overrides the manifest's frame size (e.g. oversize attacks)
+	Frames    int      `json:"frames"`
+	FPS       float32  `json:"fps"` // frames per second, each clip keeps its own pace
+	Loop      LoopMode `json:"loop"`
+
+	// Named events fired when the clip reaches a frame (0 based, within the clip),
+	// e.g. {"footstep": [2, 6]} on walk, {"cast": [5]} on spellcast (the hero's projectile leaves) or
+	// {"hit": [3]} on slash (an enemy's blow lands)
+	Events map[string][]int `json:"events,omitempty"`
+
+	// Directional clips have one row per direction ("up", "left", "down", "right", and optionally the
+	// diagonals "up_left"...). Missing diagonals use the horizontal row, and clips without directions
+	// use Row/StartCol for every direction.
+	Directions map[string]ClipRow `json:"directions,omitempty"`
+	Row        int                `json:"row"`
+	StartCol   int                `json:"startCol"`
+
+	// Layer the clip plays on (see AnimationManifest.Layers), "" replaces the whole frame
+	Layer string `json:"layer,omitempty"`
+}
+
+// AnimationLayer masks a clip to a horizontal band of the frame, in pixels from the frame's top.
+type AnimationLayer struct {
+	Priority int `json:"priority"` // higher is drawn over lower, the base clip is 0
+	Top      int `json:"top"`
+	Bottom   int `json:"bottom"`
+}
+
+type ClipRow struct {
+	Row      int `json:"row"`
+	StartCol int `json:"startCol"`
+}
+
+// ParseAnimationManifest decodes and validates a manifest. dir is the directory sheets are relative to.
+func ParseAnimationManifest(data []byte, dir string) (*AnimationManifest, error) {
+	m := &AnimationManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	m.Dir = dir
+	if m.Base == "" {
+		m.Base = "walk"
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *AnimationManifest) Validate() error {
+	base, ok := m.Clips[m.Base]
+	if !ok {
+		return fmt.Errorf("base clip %q is missing", m.Base)
+	}
+	if len(base.Directions) == 0 {
+		return fmt.Errorf("base clip %q needs per-direction rows", m.Base)
+	}
+	for _, name := range m.ClipNames() {
+		for dir := range m.Clips[name].Directions {
+			if _, ok := DirectionAngle(dir); !ok {
+				return fmt.Errorf("clip %q: unknown direction %q", name, dir)
+			}
+		}
+	}
+	if m.Idle != "" {
+		idle, ok := m.Clips[m.Idle]
+		if !ok {
+			return fmt.Errorf("idle clip %q is missing", m.Idle)
+		}
+		if len(idle.Directions) == 0 {
+			return fmt.Errorf("idle clip %q needs per-direction rows", m.Idle)
+		}
+	}
+	for _, name := range m.ClipNames() {
+		clip := m.Clips[name]
+		if clip.Frames <= 0 {
+			return fmt.Errorf("clip %q: frames must be positive", name)
+		}
+		if m.FrameSizeOf(clip) <= 0 {
+			return fmt.Errorf("clip %q: frameSize must be positive", name)
+		}
+		if m.SheetOf(clip) == "" {
+			return fmt.Errorf("clip %q: no sheet", name)
+		}
+		for event, frames := range clip.Events {
+			for _, frame := range frames {
+				if frame < 0 || frame >= clip.Frames {
+					return fmt.Errorf("clip %q: event %q on frame %d, clip only has %d frames", name, event, frame, clip.Frames)
+				}
+			}
+		}
+		if clip.Layer != "" {
+			layer, ok := m.Layers[clip.Layer]
+			if !ok {
+				return fmt.Errorf("clip %q: unknown layer %q", name, clip.Layer)
+			}
+			if layer.Top < 0 || layer.Bottom > m.FrameSize || layer.Top >= layer.Bottom {
+				return fmt.Errorf("layer %q: band %d-%d doesn't fit in %dpx frames", clip.Layer, layer.Top, layer.Bottom, m.FrameSize)
+			}
+		}
+		switch clip.Loop {
+		case "", LoopModeLoop, LoopModeOnce, LoopModePingPong, LoopModeHoldLast:
+		default:
+			return fmt.Errorf("clip %q: unknown loop mode %q", name, clip.Loop)
+		}
+	}
+	return nil
+}
+
+// ClipNames returns the clip names in a stable order.
+func (m *AnimationManifest) ClipNames() []string {
+	names := make([]string, 0, len(m.Clips))
+	for name := range m.Clips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SheetOf returns the clip's sheet path, joined with the manifest's directory.
+func (m *AnimationManifest) SheetOf(clip *AnimationClip) string {
+	sheet := clip.Sheet
+	if sheet == "" {
+		sheet = m.Sheet
+	}
+	if sheet == "" {
+		return ""
+	}
+	return path.Join(m.Dir, sheet)
+}
+
+func (m *AnimationManifest) FrameSizeOf(clip *AnimationClip) int {
+	if clip.FrameSize > 0 {
+		return clip.FrameSize
+	}
+	return m.FrameSize
+}
+
+// RowFor returns the row and first column of the clip when facing dir.
+func (c *AnimationClip) RowFor(dir string) (row int, startCol int) {
+	if r, ok := c.Directions[dir]; ok {
+		return r.Row, r.StartCol
+	}
+	if r, ok := c.Directions[CardinalOf(dir)]; ok {
+		return r.Row, r.StartCol
+	}
+	return c.Row, c.StartCol
+}
+
+// FrameRect returns the sheet rectangle of frame i when facing dir.
+func (m *AnimationManifest) FrameRect(clip *AnimationClip, dir string, i int) image.Rectangle {
+	size := m.FrameSizeOf(clip)
+	row, startCol := clip.RowFor(dir)
+	col := startCol + i
+	return image.Rect(col*size, row*size, (col+1)*size, (row+1)*size)
+}
+
+// ClipDirections returns the directions a clip is laid out in, or "" alone for clips without directions.
+func (c *AnimationClip) ClipDirections() []string {
+	if len(c.Directions) == 0 {
+		return []string{""}
+	}
+	var dirs []string
+	for _, dir := range Directions8 {
+		if _, ok := c.Directions[dir]; ok {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// CheckBounds reports the first frame of the clip that falls outside a sheet of the given size.
+func (m *AnimationManifest) CheckBounds(name string, sheetSize image.Point) error {
+	clip := m.Clips[name]
+	sheet := image.Rectangle{Max: sheetSize}
+	for _, dir := range clip.ClipDirections() {
+		for i := 0; i < clip.Frames; i++ {
+			if r := m.FrameRect(clip, dir, i); !r.In(sheet) {
+				row, col := r.Min.Y/m.FrameSizeOf(clip), r.Min.X/m.FrameSizeOf(clip)
+				return fmt.Errorf("clip %q %s frame %d (row %d, col %d) is outside the %dx%d sheet",
+					name, dir, i, row, col, sheetSize.X, sheetSize.Y)
+			}
+		}
+	}
+	return nil
+}
+
+// Mode returns the clip's loop mode, looping by default.
+func (c *AnimationClip) Mode() LoopMode {
+	if c.Loop == "" {
+		return LoopModeLoop
+	}
+	return c.Loop
+}
+
+// EventsAt returns the names of the events fired on frame i, sorted.
+func (c *AnimationClip) EventsAt(i int) []string {
+	var events []string
+	for event, frames := range c.Events {
+		for _, frame := range frames {
+			if frame == i {
+				events = append(events, event)
+			}
+		}
+	}
+	sort.Strings(events)
+	return events
+}
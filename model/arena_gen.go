@@ -0,0 +1,299 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ArenaGenSuffix marks arena generator files, which can be played like maps.
+const ArenaGenSuffix = ".gen.json"
+
+// ArenaGen describes a procedural arena, read from a .gen.json file: ground tiles laid out by noise
+// from a tileset, and props scattered from an image collection tileset (e.g. objects.tsj) by class.
+// Generate builds the same map for the same seed.
+type ArenaGen struct {
+	Width    int          `json:"width"` // in tiles
+	Height   int          `json:"height"`
+	Tileset  string       `json:"tileset"`  // ground tiles, relative to the file
+	Objects  string       `json:"objects"`  // prop images, relative to the file
+	Autotile string       `json:"autotile"` // .autotile.json picking the tiles of terrain rules, relative to the file
+	Ground   []GroundRule `json:"ground"`
+	Props    []PropRule   `json:"props"`
+	Spawns   []SpawnPlace `json:"spawns"`
+	Margin   float32      `json:"margin"` // no props closer than this to the edges, in px
+	Dir      string       `json:"-"`
+}
+
+// GroundRule fills the tiles of a layer where the noise is at least Threshold, each with a random tile
+// of Tiles, or with Terrain. Rules for the same layer paint over each other in order; a threshold of 0
+// fills the layer. Layers with terrain are autotiled once every rule has painted.
+type GroundRule struct {
+	Layer     string  `json:"layer"`
+	Tiles     []int   `json:"tiles"`   // tile ids in the tileset
+	Terrain   string  `json:"terrain"` // a terrain of the autotile description, instead of tiles
+	Scale     float64 `json:"scale"`   // noise feature size, in tiles
+	Threshold float64 `json:"threshold"`
+}
+
+// PropRule scatters Count props of a class, e.g. "tree" or "stone", from the objects tileset.
+type PropRule struct {
+	Class   string  `json:"class"`
+	MinSize int     `json:"minSize"` // only images whose longest side is at least this, in px
+	MaxSize int     `json:"maxSize"` // 0 for any
+	Count   [2]int  `json:"count"`   // min and max
+	Spacing float32 `json:"spacing"` // no other prop's center closer than this, in px
+}
+
+// SpawnPlace is a spawn point, at a fraction of the arena's size.
+type SpawnPlace struct {
+	Name  string  `json:"name"`
+	X     float32 `json:"x"`
+	Y     float32 `json:"y"`
+	Clear float32 `json:"clear"` // no solid props within this radius, in px
+}
+
+// IsArenaGen reports whether path is an arena generator rather than a Tiled map.
+func IsArenaGen(path string) bool {
+	return strings.HasSuffix(path, ArenaGenSuffix)
+}
+
+func ParseArenaGen(data []byte, dir string) (*ArenaGen, error) {
+	var g ArenaGen
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	g.Dir = dir
+	if g.Width <= 0 || g.Height <= 0 {
+		return nil, fmt.Errorf("arena is %dx%d tiles", g.Width, g.Height)
+	}
+	if g.Tileset == "" || g.Objects == "" {
+		return nil, fmt.Errorf("needs a tileset and an objects tileset")
+	}
+	if g.Margin < 0 {
+		return nil, fmt.Errorf("margin %g is negative", g.Margin)
+	}
+	for i, rule := range g.Ground {
+		if rule.Layer == "" || (len(rule.Tiles) == 0) == (rule.Terrain == "") {
+			return nil, fmt.Errorf("ground rule %d: needs a layer, and tiles or a terrain", i)
+		}
+		if rule.Terrain != "" && g.Autotile == "" {
+			return nil, fmt.Errorf("ground rule %d: terrain %q needs an autotile description", i, rule.Terrain)
+		}
+		if rule.Threshold > 0 && rule.Scale <= 0 {
+			return nil, fmt.Errorf("ground rule %d: a threshold needs a noise scale above 0, got %g", i, rule.Scale)
+		}
+	}
+	for _, rule := range g.Props {
+		if rule.Count[1] < rule.Count[0] {
+			return nil, fmt.Errorf("props %q: count %v, the max is below the min", rule.Class, rule.Count)
+		}
+	}
+	return &g, nil
+}
+
+// placedProp is a prop already in the arena, for spacing
+type placedProp struct {
+	center  Vec2
+	spacing float32
+}
+
+// Generate lays out the arena for seed. Tilesets are loaded with read, given paths joined with Dir.
+// Props collide as their tile says, see TiledMap.Colliders.
+func (g *ArenaGen) Generate(seed int64, read func(path string) ([]byte, error)) (*TiledMap, error) {
+	m := &TiledMap{Width: g.Width, Height: g.Height, Dir: g.Dir}
+	m.Tilesets = []*TiledTileset{{FirstGID: 1, Source: g.Tileset}}
+	if err := m.ResolveTilesets(read); err != nil {
+		return nil, err
+	}
+	ground := m.Tilesets[0]
+	m.TileWidth, m.TileHeight = ground.TileWidth, ground.TileHeight
+	m.Tilesets = append(m.Tilesets, &TiledTileset{FirstGID: 1 + uint32(ground.TileCount), Source: g.Objects})
+	if err := m.ResolveTilesets(read); err != nil {
+		return nil, err
+	}
+	objects := m.Tilesets[1]
+
+	var autotile *Autotile
+	if g.Autotile != "" {
+		p := path.Join(g.Dir, g.Autotile)
+		data, err := read(p)
+		if err != nil {
+			return nil, err
+		}
+		if autotile, err = ParseAutotile(data, path.Dir(p)); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if m.TilesetAt(path.Join(autotile.Dir, autotile.Tileset)) != ground {
+			return nil, fmt.Errorf("%s describes %s, not the ground tileset %s", p, autotile.Tileset, g.Tileset)
+		}
+		if err := autotile.checkTiles(ground.TileCount); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+
+	// ground, terrain first and its tiles picked after
+	layers := make(map[string]*TiledLayer)
+	terrains := make(map[*TiledLayer][]int)
+	for i, rule := range g.Ground {
+		layer, ok := layers[rule.Layer]
+		if !ok {
+			layer = &TiledLayer{Name: rule.Layer, Type: TiledTileLayer, Visible: true, Opacity: 1, Tiles: make([]uint32, g.Width*g.Height)}
+			layers[rule.Layer] = layer
+			m.Layers = append(m.Layers, layer)
+		}
+		terrain := -1
+		if rule.Terrain != "" {
+			if terrain = autotile.TerrainNamed(rule.Terrain); terrain < 0 {
+				return nil, fmt.Errorf("ground layer %q: no terrain %q in %s", rule.Layer, rule.Terrain, g.Autotile)
+			}
+			if terrains[layer] == nil {
+				terrains[layer] = make([]int, len(layer.Tiles))
+				for j := range terrains[layer] {
+					terrains[layer][j] = -1
+				}
+			}
+		}
+		noiseSeed := seed + int64(i)*7919
+		for y := 0; y < g.Height; y++ {
+			for x := 0; x < g.Width; x++ {
+				if rule.Threshold > 0 && fractalNoise(noiseSeed, float64(x)/rule.Scale, float64(y)/rule.Scale) < rule.Threshold {
+					continue
+				}
+				if terrain >= 0 {
+					terrains[layer][y*g.Width+x] = terrain
+					continue
+				}
+				tile := rule.Tiles[rng.Intn(len(rule.Tiles))]
+				if tile < 0 || tile >= ground.TileCount {
+					return nil, fmt.Errorf("ground layer %q: tile %d isn't in %s", rule.Layer, tile, g.Tileset)
+				}
+				layer.Tiles[y*g.Width+x] = ground.FirstGID + uint32(tile)
+				if terrains[layer] != nil {
+					terrains[layer][y*g.Width+x] = autotile.TerrainOf(tile)
+				}
+			}
+		}
+	}
+	for layer, terrain := range terrains {
+		for i, id := range autotile.Resolve(terrain, g.Width, g.Height, seed) {
+			if id >= 0 {
+				layer.Tiles[i] = ground.FirstGID + uint32(id)
+			}
+		}
+	}
+
+	// spawn points, props keep clear of them
+	nextID := 1
+	spawnLayer := &TiledLayer{Name: "spawns", Type: TiledObjectGroup, Visible: true, Opacity: 1}
+	w, h := float32(g.Width*m.TileWidth), float32(g.Height*m.TileHeight)
+	for _, s := range g.Spawns {
+		spawnLayer.Objects = append(spawnLayer.Objects, &TiledObject{ID: nextID, Name: s.Name, Class: "spawn", X: s.X * w, Y: s.Y * h, Point: true})
+		nextID++
+	}
+
+	// props
+	propLayer := &TiledLayer{Name: "props", Type: TiledObjectGroup, Visible: true, Opacity: 1}
+	var placed []placedProp
+	for _, rule := range g.Props {
+		tiles := g.propTiles(objects, rule)
+		if len(tiles) == 0 {
+			return nil, fmt.Errorf("props %q: no images of that class in %s", rule.Class, g.Objects)
+		}
+		// images wider or taller than the room inside the margin can't be placed
+		tiles = slices.DeleteFunc(tiles, func(t *TiledTile) bool {
+			return float32(t.Width) > w-2*g.Margin || float32(t.Height) > h-2*g.Margin
+		})
+		if len(tiles) == 0 {
+			return nil, fmt.Errorf("props %q: no images fit inside a %g px margin of the %gx%g px arena", rule.Class, g.Margin, w, h)
+		}
+		count := rule.Count[0] + rng.Intn(rule.Count[1]-rule.Count[0]+1)
+		for n, attempts := 0, 0; n < count && attempts < count*30; attempts++ {
+			tile := tiles[rng.Intn(len(tiles))]
+			pw, ph := float32(tile.Width), float32(tile.Height)
+			x := g.Margin + rng.Float32()*(w-2*g.Margin-pw)
+			y := g.Margin + ph + rng.Float32()*(h-2*g.Margin-ph)
+			center := Vec2{X: x + pw/2, Y: y - ph/2}
+			if !g.fits(center, tile, rule, placed, w, h) {
+				continue
+			}
+			placed = append(placed, placedProp{center: center, spacing: rule.Spacing})
+			propLayer.Objects = append(propLayer.Objects, &TiledObject{ID: nextID, Class: rule.Class, X: x, Y: y, Width: pw, Height: ph, GID: objects.FirstGID + uint32(tile.ID)})
+			nextID++
+			n++
+		}
+	}
+	// lower props are in front
+	sort.SliceStable(propLayer.Objects, func(i, j int) bool { return propLayer.Objects[i].Y < propLayer.Objects[j].Y })
+
+	m.Layers = append(m.Layers, propLayer, spawnLayer)
+	return m, nil
+}
+
+// propTiles are the images of the objects tileset a rule can place, in id order.
+func (g *ArenaGen) propTiles(objects *TiledTileset, rule PropRule) []*TiledTile {
+	var tiles []*TiledTile
+	for _, tile := range objects.Tiles {
+		size := max(tile.Width, tile.Height)
+		if tile.Class != rule.Class || size < rule.MinSize || (rule.MaxSize > 0 && size > rule.MaxSize) {
+			continue
+		}
+		tiles = append(tiles, tile)
+	}
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].ID < tiles[j].ID })
+	return tiles
+}
+
+// fits checks a prop centered at center keeps its spacing from every other prop (the larger of the
+// two spacings wins), and solid props keep clear of spawn points.
+func (g *ArenaGen) fits(center Vec2, tile *TiledTile, rule PropRule, placed []placedProp, w float32, h float32) bool {
+	for _, p := range placed {
+		if center.Distance(&p.center) < max(rule.Spacing, p.spacing) {
+			return false
+		}
+	}
+	if !tile.Properties.Bool("solid") {
+		return true
+	}
+	for _, s := range g.Spawns {
+		spawn := Vec2{X: s.X * w, Y: s.Y * h}
+		if center.Distance(&spawn) < s.Clear {
+			return false
+		}
+	}
+	return true
+}
+
+// fractalNoise is two octaves of value noise, from 0 to 1.
+func fractalNoise(seed int64, x float64, y float64) float64 {
+	return (valueNoise(seed, x, y)*2 + valueNoise(seed+1, x*2, y*2)) / 3
+}
+
+// valueNoise interpolates random values on the integer lattice, from 0 to 1.
+func valueNoise(seed int64, x float64, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	tx, ty := smoothstep(x-x0), smoothstep(y-y0)
+	ix, iy := int64(x0), int64(y0)
+	top := lerp(latticeValue(seed, ix, iy), latticeValue(seed, ix+1, iy), tx)
+	bottom := lerp(latticeValue(seed, ix, iy+1), latticeValue(seed, ix+1, iy+1), tx)
+	return lerp(top, bottom, ty)
+}
+
+func latticeValue(seed int64, x int64, y int64) float64 {
+	h := uint64(seed)*0x9E3779B97F4A7C15 ^ uint64(x)*0xBF58476D1CE4E5B9 ^ uint64(y)*0x94D049BB133111EB
+	h ^= h >> 31
+	h *= 0xD6E8FEB86659FD93
+	h ^= h >> 32
+	return float64(h>>11) / float64(1<<53)
+}
+
+func smoothstep(t float64) float64 { return t * t * (3 - 2*t) }
+
+func lerp(a float64, b float64, t float64) float64 { return a + (b-a)*t }
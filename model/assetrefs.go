@@ -0,0 +1,56 @@
+package model
+
+import (
+	"image"
+	"path"
+)
+
+// Assets the game loads by path, rather than through a manifest, map or effect file.
+// Shared by the game and cmd/validateassets so they can't drift apart.
+const (
+	TileSize = 32
+
+	MapsDir    = "assets/maps"
+	DefaultMap = "assets/maps/arena.tmj"
+
+	EffectsDir    = "assets/effects"
+	CharactersDir = "assets/characters"
+
+	SkeletonManifest = "assets/enemies/skeleton.anim.json"
+	HeroManifest     = "assets/characters/default.anim.json"
+
+	HealthSheet  = "assets/toolbar/health.png"
+	ManaSheet    = "assets/toolbar/mana.png"
+	StaminaSheet = "assets/toolbar/stamina.png"
+
+	StatusFrameSize = 32
+	StatusFrames    = 5 // full to empty
+)
+
+// HeroPaths are the LPC character directory and animation manifest of the hero called name.
+func HeroPaths(name string) (dir string, manifest string) {
+	dir = path.Join(CharactersDir, name)
+	return dir, path.Join(dir, name+".anim.json")
+}
+
+// ImageRef is an image the game loads, and the smallest size that holds every frame it cuts from it.
+type ImageRef struct {
+	Path    string
+	MinSize image.Point
+	Usage   string // what it's for, for error messages
+}
+
+// GameImageRefs lists the images the game loads directly.
+func GameImageRefs() []ImageRef {
+	statusSize := image.Pt(StatusFrames*StatusFrameSize, StatusFrameSize)
+	return []ImageRef{
+		{Path: HealthSheet, MinSize: statusSize, Usage: "health bar"},
+		{Path: ManaSheet, MinSize: statusSize, Usage: "mana bar"},
+		{Path: StaminaSheet, MinSize: statusSize, Usage: "stamina bar"},
+	}
+}
+
+// GameManifests lists the animation manifests the game always loads.
+func GameManifests() []string {
+	return []string{SkeletonManifest, HeroManifest}
+}
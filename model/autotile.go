@@ -0,0 +1,272 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"path"
+)
+
+// Autotile picks ground tiles from terrain, read from a .autotile.json tileset description (e.g.
+// assets/maps/fields.autotile.json). Each cell of a terrain uses a fill tile, unless a neighbour is a
+// terrain listed after it: then it uses a transition tile whose sides show that terrain bleeding in.
+// Tiles listed more than once are picked more often.
+type Autotile struct {
+	Tileset     string        `json:"tileset"` // relative to the description
+	Terrains    []*Terrain    `json:"terrains"`
+	Transitions []*Transition `json:"transitions"`
+	Dir         string        `json:"-"`
+
+	terrainOf map[int]int // tile id -> terrain index
+}
+
+type Terrain struct {
+	Name string `json:"name"`
+	Fill []int  `json:"fill"` // tile ids for cells with no neighbours of a later terrain
+}
+
+// Transition tiles are a cell of Terrain whose Sides touch Border. A side is an edge ("n", "e", "s",
+// "w") or a corner ("ne", "se", "sw", "nw"). Corners only need listing when neither of their edges is.
+type Transition struct {
+	Terrain string   `json:"terrain"`
+	Border  string   `json:"border"`
+	Sides   []string `json:"sides"`
+	Tiles   []int    `json:"tiles"`
+
+	terrain int
+	border  int
+	mask    uint8
+}
+
+// neighbour sides as bits, clockwise from north
+var autotileSides = []struct {
+	name   string
+	dx, dy int
+}{
+	{"n", 0, -1}, {"ne", 1, -1}, {"e", 1, 0}, {"se", 1, 1},
+	{"s", 0, 1}, {"sw", -1, 1}, {"w", -1, 0}, {"nw", -1, -1},
+}
+
+const autotileEdges uint8 = 0b01010101
+
+func ParseAutotile(data []byte, dir string) (*Autotile, error) {
+	var a Autotile
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	a.Dir = dir
+	if a.Tileset == "" {
+		return nil, fmt.Errorf("no tileset")
+	}
+	if len(a.Terrains) == 0 {
+		return nil, fmt.Errorf("no terrains")
+	}
+	a.terrainOf = make(map[int]int)
+	for i, t := range a.Terrains {
+		if len(t.Fill) == 0 {
+			return nil, fmt.Errorf("terrain %q has no fill tiles", t.Name)
+		}
+		for _, id := range t.Fill {
+			a.terrainOf[id] = i
+		}
+	}
+	for _, tr := range a.Transitions {
+		tr.terrain, tr.border = a.TerrainNamed(tr.Terrain), a.TerrainNamed(tr.Border)
+		if tr.terrain < 0 || tr.border < 0 {
+			return nil, fmt.Errorf("transition %s/%s: unknown terrain", tr.Terrain, tr.Border)
+		}
+		if tr.border <= tr.terrain {
+			return nil, fmt.Errorf("transition %s/%s: %s must be listed after %s to border it", tr.Terrain, tr.Border, tr.Border, tr.Terrain)
+		}
+		if len(tr.Tiles) == 0 {
+			return nil, fmt.Errorf("transition %s/%s %v has no tiles", tr.Terrain, tr.Border, tr.Sides)
+		}
+		for _, side := range tr.Sides {
+			bit := sideBit(side)
+			if bit == 0 {
+				return nil, fmt.Errorf("transition %s/%s: unknown side %q, use n, ne, e, se, s, sw, w or nw", tr.Terrain, tr.Border, side)
+			}
+			tr.mask |= bit
+		}
+		tr.mask = withCorners(tr.mask)
+		for _, id := range tr.Tiles {
+			a.terrainOf[id] = tr.terrain
+		}
+	}
+	return &a, nil
+}
+
+// checkTiles fails if a fill or transition tile isn't one of the count tiles of the described tileset.
+func (a *Autotile) checkTiles(count int) error {
+	for _, t := range a.Terrains {
+		for _, id := range t.Fill {
+			if id < 0 || id >= count {
+				return fmt.Errorf("terrain %q: fill tile %d isn't in %s, it has %d tiles", t.Name, id, a.Tileset, count)
+			}
+		}
+	}
+	for _, tr := range a.Transitions {
+		for _, id := range tr.Tiles {
+			if id < 0 || id >= count {
+				return fmt.Errorf("transition %s/%s %v: tile %d isn't in %s, it has %d tiles", tr.Terrain, tr.Border, tr.Sides, id, a.Tileset, count)
+			}
+		}
+	}
+	return nil
+}
+
+// TerrainNamed returns the index of the terrain called name, -1 if there's none.
+func (a *Autotile) TerrainNamed(name string) int {
+	for i, t := range a.Terrains {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// TerrainOf returns the terrain tile id is a fill or transition of, -1 if it's in neither.
+func (a *Autotile) TerrainOf(id int) int {
+	if t, ok := a.terrainOf[id]; ok {
+		return t
+	}
+	return -1
+}
+
+// Resolve picks a tile for every cell of a width x height terrain grid (terrain indices row by row,
+// -1 to leave a cell alone, which then borders nothing). The same seed picks the same variations.
+func (a *Autotile) Resolve(terrain []int, width int, height int, seed int64) []int {
+	tiles := make([]int, len(terrain))
+	for i, t := range terrain {
+		if t < 0 {
+			tiles[i] = -1
+			continue
+		}
+		x, y := i%width, i/width
+
+		// the highest terrain around, and where it is
+		border := -1
+		var mask uint8
+		for bit, side := range autotileSides {
+			nx, ny := x+side.dx, y+side.dy
+			if nx < 0 || ny < 0 || nx >= width || ny >= height {
+				continue
+			}
+			n := terrain[ny*width+nx]
+			if n <= t {
+				continue
+			}
+			if n > border {
+				border, mask = n, 0
+			}
+			if n == border {
+				mask |= 1 << bit
+			}
+		}
+		tiles[i] = pickTile(a.candidates(t, border, withCorners(mask)), x, y, seed)
+	}
+	return tiles
+}
+
+// candidates are the tiles that best show terrain t with border on the sides in mask. Showing the
+// border where it isn't costs more than missing it, and edges count double corners.
+func (a *Autotile) candidates(t int, border int, mask uint8) []int {
+	if mask == 0 {
+		return a.Terrains[t].Fill
+	}
+	best := sideCost(mask, 0)
+	tiles := a.Terrains[t].Fill
+	for _, tr := range a.Transitions {
+		if tr.terrain != t || tr.border != border {
+			continue
+		}
+		cost := sideCost(mask, tr.mask)
+		switch {
+		case cost < best:
+			best, tiles = cost, tr.Tiles
+		case cost == best:
+			tiles = append(append([]int(nil), tiles...), tr.Tiles...)
+		}
+	}
+	return tiles
+}
+
+func sideCost(want uint8, has uint8) int {
+	missing, extra := want&^has, has&^want
+	weight := func(m uint8) int { return bits.OnesCount8(m&autotileEdges)*2 + bits.OnesCount8(m&^autotileEdges) }
+	return weight(missing) + 2*weight(extra)
+}
+
+// withCorners adds the corners of every edge in mask, the border covers them too.
+func withCorners(mask uint8) uint8 {
+	for bit := 0; bit < 8; bit += 2 {
+		if mask&(1<<bit) != 0 {
+			mask |= 1<<((bit+1)%8) | 1<<((bit+7)%8)
+		}
+	}
+	return mask
+}
+
+func sideBit(name string) uint8 {
+	for bit, side := range autotileSides {
+		if side.name == name {
+			return 1 << bit
+		}
+	}
+	return 0
+}
+
+// AutotileLayer re-picks the tiles of layer from the terrain they're in. Tiles of other tilesets, or
+// missing from the description, are kept and border nothing.
+func (m *TiledMap) AutotileLayer(layer *TiledLayer, a *Autotile, seed int64) error {
+	ts := m.TilesetAt(path.Join(a.Dir, a.Tileset))
+	if ts == nil {
+		return fmt.Errorf("layer %q: the map doesn't use %s", layer.Name, path.Join(a.Dir, a.Tileset))
+	}
+	if err := a.checkTiles(ts.TileCount); err != nil {
+		return fmt.Errorf("layer %q: %w", layer.Name, err)
+	}
+	terrain := make([]int, len(layer.Tiles))
+	for i, gid := range layer.Tiles {
+		terrain[i] = -1
+		if tileset, id := m.TilesetFor(gid); tileset == ts {
+			terrain[i] = a.TerrainOf(id)
+		}
+	}
+	for i, id := range a.Resolve(terrain, m.Width, m.Height, seed) {
+		if id >= 0 {
+			layer.Tiles[i] = ts.FirstGID + uint32(id)
+		}
+	}
+	return nil
+}
+
+// AutotileLayers autotiles every tile layer with an "autotile" property, the path of its description
+// relative to the map. Descriptions are loaded with read. Hand made maps always get the same variations.
+func (m *TiledMap) AutotileLayers(read func(path string) ([]byte, error)) error {
+	for _, layer := range m.Layers {
+		desc := layer.Properties["autotile"]
+		if desc == "" || layer.Type != TiledTileLayer {
+			continue
+		}
+		p := path.Join(m.Dir, desc)
+		data, err := read(p)
+		if err != nil {
+			return fmt.Errorf("layer %q: %w", layer.Name, err)
+		}
+		a, err := ParseAutotile(data, path.Dir(p))
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if err := m.AutotileLayer(layer, a, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickTile picks one of tiles for cell (x, y), the same one every time for the same seed.
+func pickTile(tiles []int, x int, y int, seed int64) int {
+	h := uint64(latticeValue(seed, int64(x), int64(y)) * float64(1<<53))
+	return tiles[h%uint64(len(tiles))]
+}
@@ -0,0 +1,152 @@
+/*
+This file contains a flow field over a walk grid: the walking distance from every cell to one goal cell,
+so any number of characters heading for the same goal each find their way with a neighbour lookup.
+*/
+package model
+
+import (
+	"container/heap"
+	"image"
+	"math"
+)
+
+// FlowField is built toward a goal over several Steps, a budget of cells at a time, while Direction keeps
+// reading the last complete field. A build always runs to the end: retargeting while one is in progress
+// queues the goal for the next build, so a goal that keeps moving still gets a complete field every build.
+type FlowField struct {
+	Grid *WalkGrid
+	Goal image.Point // of the complete field
+
+	dist  []float64 // walking distance to Goal by cell, +Inf where it can't be reached
+	ready bool
+
+	// the field being built
+	building bool
+	nextGoal image.Point
+	next     []float64
+	open     pathQueue
+
+	// the latest goal asked for during a build, built after it
+	queued     bool
+	queuedGoal image.Point
+}
+
+func NewFlowField(grid *WalkGrid) *FlowField {
+	return &FlowField{
+		Grid: grid,
+		dist: make([]float64, grid.Width*grid.Height),
+		next: make([]float64, grid.Width*grid.Height),
+	}
+}
+
+// Retarget builds the field toward goal, unless it's already built or being built toward it. During a
+// build, goal is queued and built once the current one is done. The first field is built at once, so
+// there's always one to read.
+func (f *FlowField) Retarget(goal image.Point) {
+	goal, ok := f.Grid.Nearest(goal, 4)
+	switch {
+	case !ok:
+		return
+	case f.building:
+		f.queued, f.queuedGoal = goal != f.nextGoal, goal
+		return
+	case f.ready && goal == f.Goal:
+		return
+	}
+	f.start(goal)
+	for !f.ready && f.Step(len(f.next)) {
+	}
+}
+
+// start begins building the field toward goal.
+func (f *FlowField) start(goal image.Point) {
+	f.building, f.nextGoal = true, goal
+	for i := range f.next {
+		f.next[i] = math.Inf(1)
+	}
+	f.next[goal.Y*f.Grid.Width+goal.X] = 0
+	f.open = f.open[:0]
+	heap.Push(&f.open, pathNode{cell: goal})
+}
+
+// Step expands up to budget cells of the field being built, and swaps it in when it's complete, starting
+// the queued goal's build if there is one. It reports whether a build is still in progress.
+func (f *FlowField) Step(budget int) bool {
+	g := f.Grid
+	for ; f.building && budget > 0; budget-- {
+		if f.open.Len() == 0 {
+			f.dist, f.next = f.next, f.dist
+			f.Goal, f.ready, f.building = f.nextGoal, true, false
+			if f.queued && f.queuedGoal != f.Goal {
+				f.start(f.queuedGoal)
+			}
+			f.queued = false
+			break
+		}
+		node := heap.Pop(&f.open).(pathNode)
+		cur := node.cell
+		if node.priority > f.next[cur.Y*g.Width+cur.X] {
+			// stale, already reached more cheaply
+			continue
+		}
+		for _, step := range walkSteps {
+			n := cur.Add(step.d)
+			if !f.walkable(cur, step.d) {
+				continue
+			}
+			d := node.priority + step.cost
+			if d < f.next[n.Y*g.Width+n.X] {
+				f.next[n.Y*g.Width+n.X] = d
+				heap.Push(&f.open, pathNode{cell: n, priority: d})
+			}
+		}
+	}
+	return f.building
+}
+
+// walkable reports whether a step of d from cell c stays on walkable cells, without cutting corners.
+func (f *FlowField) walkable(c image.Point, d image.Point) bool {
+	g := f.Grid
+	n := c.Add(d)
+	if !g.Walkable(n) {
+		return false
+	}
+	return d.X == 0 || d.Y == 0 || (g.Walkable(image.Point{X: n.X, Y: c.Y}) && g.Walkable(image.Point{X: c.X, Y: n.Y}))
+}
+
+// Distance returns the walking distance from cell c to the goal, in cells, +Inf if it can't get there.
+func (f *FlowField) Distance(c image.Point) float64 {
+	if !f.ready || !f.Grid.Walkable(c) {
+		return math.Inf(1)
+	}
+	return f.dist[c.Y*f.Grid.Width+c.X]
+}
+
+// Direction returns the way to walk from pixel p toward the goal: to the center of the neighbouring
+// cell closest to it. It's the zero vector in the goal cell, or where the goal can't be reached.
+// A blocked cell, e.g. a character pushed into an obstacle, walks to its nearest walkable cell.
+func (f *FlowField) Direction(p Vec2) *Vec2 {
+	g := f.Grid
+	c := g.CellAt(image.Point{X: int(math.Floor(float64(p.X))), Y: int(math.Floor(float64(p.Y)))})
+	best, bestDist := c, f.Distance(c)
+	if math.IsInf(bestDist, 1) {
+		var ok bool
+		if best, ok = g.Nearest(c, 2); !ok {
+			return Vec2Zero
+		}
+	} else {
+		for _, step := range walkSteps {
+			if !f.walkable(c, step.d) {
+				continue
+			}
+			if d := f.Distance(c.Add(step.d)); d < bestDist {
+				best, bestDist = c.Add(step.d), d
+			}
+		}
+	}
+	if best == c {
+		return Vec2Zero
+	}
+	center := g.Center(best)
+	return (&Vec2{X: float32(center.X) - p.X, Y: float32(center.Y) - p.Y}).Norm()
+}
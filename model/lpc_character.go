@@ -0,0 +1,180 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LPCCharacter is the character.json exported by the Universal LPC Spritesheet Character Generator:
+// the layers (body, clothes, weapon...) a character is built from, drawn in zPos order.
+type LPCCharacter struct {
+	BodyTypeName string       `json:"bodyTypeName"`
+	Version      int          `json:"version"`
+	Layers       []LPCLayer   `json:"layers"`
+	Credits      []LPCCredits `json:"credits"`
+}
+
+type LPCLayer struct {
+	FileName            string `json:"fileName"`   // relative to the generator's spritesheets directory
+	ZPos                int    `json:"zPos"`       // higher is drawn on top
+	ParentName          string `json:"parentName"` // equipment slot, e.g. "weapon", "hat"
+	Name                string `json:"name"`
+	Variant             string `json:"variant"`
+	SupportedAnimations string `json:"supportedAnimations"`        // comma separated generator names, e.g. "walk,1h_slash"
+	CustomAnimation     string `json:"custom_animation,omitempty"` // only drawn in this custom animation, e.g. "thrust_oversize"
+}
+
+type LPCCredits struct {
+	FileName string `json:"fileName"`
+	Licenses string `json:"licenses"`
+	Authors  string `json:"authors"`
+	URLs     string `json:"urls"`
+	Notes    string `json:"notes"`
+}
+
+// LPCAnimation is one animation of the exported standard/ sheets.
+type LPCAnimation struct {
+	Name   string // file name in standard/, e.g. "combat_idle"
+	Alias  string // name in supportedAnimations when it differs, e.g. "combat"
+	Frames int
+	Rows   int // 4 (up, left, down, right), or 1 for hurt and climb
+}
+
+// LPCFrameSize is the size of a standard LPC frame
+const LPCFrameSize = 64
+
+// LPCAnimations are the standard animations, with the frame counts of the generator's export.
+var LPCAnimations = []LPCAnimation{
+	{Name: "spellcast", Frames: 7, Rows: 4},
+	{Name: "thrust", Frames: 8, Rows: 4},
+	{Name: "walk", Frames: 9, Rows: 4},
+	{Name: "slash", Frames: 6, Rows: 4},
+	{Name: "shoot", Frames: 13, Rows: 4},
+	{Name: "hurt", Frames: 6, Rows: 1},
+	{Name: "climb", Frames: 6, Rows: 1},
+	{Name: "idle", Frames: 2, Rows: 4},
+	{Name: "jump", Frames: 5, Rows: 4},
+	{Name: "sit", Frames: 3, Rows: 4},
+	{Name: "emote", Frames: 3, Rows: 4},
+	{Name: "run", Frames: 8, Rows: 4},
+	{Name: "combat_idle", Alias: "combat", Frames: 2, Rows: 4},
+	{Name: "backslash", Alias: "1h_backslash", Frames: 13, Rows: 4},
+	{Name: "halfslash", Alias: "1h_halfslash", Frames: 7, Rows: 4},
+}
+
+// LPCCustomAnimation is an oversize variant of a standard animation, exported to custom/.
+// Standard layers are centered in its bigger frames, under/over the custom layers by zPos.
+type LPCCustomAnimation struct {
+	Name      string
+	Base      string // standard animation it extends
+	FrameSize int
+}
+
+var LPCCustomAnimations = []LPCCustomAnimation{
+	{Name: "thrust_oversize", Base: "thrust", FrameSize: 192},
+	{Name: "slash_oversize", Base: "slash", FrameSize: 192},
+	{Name: "slash_reverse_oversize", Base: "slash", FrameSize: 192},
+	{Name: "walk_128", Base: "walk", FrameSize: 128},
+	{Name: "thrust_128", Base: "thrust", FrameSize: 128},
+	{Name: "slash_128", Base: "slash", FrameSize: 128},
+}
+
+func ParseLPCCharacter(data []byte) (*LPCCharacter, error) {
+	c := &LPCCharacter{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	for i, layer := range c.Layers {
+		if layer.FileName == "" {
+			return nil, fmt.Errorf("layer %d (%s): no fileName", i, layer.ParentName)
+		}
+		if layer.CustomAnimation != "" && LPCCustomAnimationNamed(layer.CustomAnimation) == nil {
+			return nil, fmt.Errorf("layer %d (%s): unknown custom animation %q", i, layer.ParentName, layer.CustomAnimation)
+		}
+	}
+	return c, nil
+}
+
+func LPCAnimationNamed(name string) *LPCAnimation {
+	for i := range LPCAnimations {
+		if LPCAnimations[i].Name == name || LPCAnimations[i].Alias == name {
+			return &LPCAnimations[i]
+		}
+	}
+	return nil
+}
+
+func LPCCustomAnimationNamed(name string) *LPCCustomAnimation {
+	for i := range LPCCustomAnimations {
+		if LPCCustomAnimations[i].Name == name {
+			return &LPCCustomAnimations[i]
+		}
+	}
+	return nil
+}
+
+// SortedLayers returns the layers bottom to top. Layers with the same zPos keep their file order.
+func (c *LPCCharacter) SortedLayers() []LPCLayer {
+	layers := append([]LPCLayer(nil), c.Layers...)
+	sort.SliceStable(layers, func(i, j int) bool { return layers[i].ZPos < layers[j].ZPos })
+	return layers
+}
+
+// LayersFor returns the layers in a slot (parentName), e.g. the weapon's background and foreground.
+func (c *LPCCharacter) LayersFor(parentName string) []LPCLayer {
+	var layers []LPCLayer
+	for _, layer := range c.Layers {
+		if layer.ParentName == parentName {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// SetSlot replaces every layer in the slot parentName with layers (none empties the slot).
+func (c *LPCCharacter) SetSlot(parentName string, layers ...LPCLayer) {
+	kept := c.Layers[:0:0]
+	for _, layer := range c.Layers {
+		if layer.ParentName != parentName {
+			kept = append(kept, layer)
+		}
+	}
+	for _, layer := range layers {
+		layer.ParentName = parentName
+		kept = append(kept, layer)
+	}
+	c.Layers = kept
+}
+
+// Supports reports whether the layer has frames for the standard animation anim.
+func (l LPCLayer) Supports(anim *LPCAnimation) bool {
+	for _, name := range strings.Split(l.SupportedAnimations, ",") {
+		name = strings.TrimSpace(name)
+		if name == anim.Name || (anim.Alias != "" && name == anim.Alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// SheetFor is the layer's sheet for a standard animation, relative to the spritesheets directory.
+// The generator keeps one sheet per animation: body/bodies/male/light.png -> body/bodies/male/walk/light.png.
+// Custom animation layers are a single sheet, their fileName.
+func (l LPCLayer) SheetFor(anim *LPCAnimation) string {
+	if l.CustomAnimation != "" {
+		return l.FileName
+	}
+	return path.Join(path.Dir(l.FileName), anim.Name, path.Base(l.FileName))
+}
+
+// StandardSheet is the pre-composited export of anim, relative to the character's directory.
+func (a *LPCAnimation) StandardSheet() string {
+	return path.Join("standard", a.Name+".png")
+}
+
+func (a *LPCCustomAnimation) CustomSheet() string {
+	return path.Join("custom", a.Name+".png")
+}
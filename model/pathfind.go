@@ -0,0 +1,217 @@
+/*
+This file contains the walkability grid characters path over, derived from a map's colliders, and an A*
+search over it.
+*/
+package model
+
+import (
+	"container/heap"
+	"image"
+	"math"
+)
+
+// WalkGrid marks which cells of a map a character can stand in the middle of.
+type WalkGrid struct {
+	Width, Height int
+	CellSize      int    // px
+	Blocked       []bool // row by row
+}
+
+// WalkGrid splits the map into cells of cellSize px. A cell is blocked when a square of half size
+// clearance centered on it overlaps a collider, so a character no wider than that fits anywhere a path goes.
+func (m *TiledMap) WalkGrid(cellSize int, clearance int) *WalkGrid {
+	g := &WalkGrid{
+		Width:    (m.Width*m.TileWidth + cellSize - 1) / cellSize,
+		Height:   (m.Height*m.TileHeight + cellSize - 1) / cellSize,
+		CellSize: cellSize,
+	}
+	g.Blocked = make([]bool, g.Width*g.Height)
+	for _, c := range m.Colliders() {
+		r := c.Rect.Inset(-clearance)
+		lo, hi := g.CellAt(r.Min), g.CellAt(r.Max)
+		for y := max(lo.Y, 0); y <= hi.Y && y < g.Height; y++ {
+			for x := max(lo.X, 0); x <= hi.X && x < g.Width; x++ {
+				// blocked if the center is strictly inside the grown collider
+				center := g.Center(image.Point{X: x, Y: y})
+				if center.X > r.Min.X && center.X < r.Max.X && center.Y > r.Min.Y && center.Y < r.Max.Y {
+					g.Blocked[y*g.Width+x] = true
+				}
+			}
+		}
+	}
+	return g
+}
+
+// CellAt returns the cell containing pixel p, which may be outside the grid.
+func (g *WalkGrid) CellAt(p image.Point) image.Point {
+	return image.Point{X: floorDiv(p.X, g.CellSize), Y: floorDiv(p.Y, g.CellSize)}
+}
+
+// Center returns the pixel in the middle of cell c.
+func (g *WalkGrid) Center(c image.Point) image.Point {
+	return image.Point{X: c.X*g.CellSize + g.CellSize/2, Y: c.Y*g.CellSize + g.CellSize/2}
+}
+
+// Walkable reports whether c is in the grid and not blocked.
+func (g *WalkGrid) Walkable(c image.Point) bool {
+	return c.X >= 0 && c.Y >= 0 && c.X < g.Width && c.Y < g.Height && !g.Blocked[c.Y*g.Width+c.X]
+}
+
+// Nearest returns the walkable cell closest to c, searching up to radius cells away, and false if there's none.
+func (g *WalkGrid) Nearest(c image.Point, radius int) (image.Point, bool) {
+	for r := 0; r <= radius; r++ {
+		best, bestDist := image.Point{}, -1
+		// the ring of cells r away
+		for y := c.Y - r; y <= c.Y+r; y++ {
+			for x := c.X - r; x <= c.X+r; x++ {
+				if max(abs(x-c.X), abs(y-c.Y)) != r || !g.Walkable(image.Point{X: x, Y: y}) {
+					continue
+				}
+				if d := (x-c.X)*(x-c.X) + (y-c.Y)*(y-c.Y); bestDist < 0 || d < bestDist {
+					best, bestDist = image.Point{X: x, Y: y}, d
+				}
+			}
+		}
+		if bestDist >= 0 {
+			return best, true
+		}
+	}
+	return image.Point{}, false
+}
+
+// 8 neighbours, the cost of a step is its length
+var walkSteps = []struct {
+	d    image.Point
+	cost float64
+}{
+	{image.Point{X: 1}, 1}, {image.Point{X: -1}, 1}, {image.Point{Y: 1}, 1}, {image.Point{Y: -1}, 1},
+	{image.Point{X: 1, Y: 1}, math.Sqrt2}, {image.Point{X: 1, Y: -1}, math.Sqrt2},
+	{image.Point{X: -1, Y: 1}, math.Sqrt2}, {image.Point{X: -1, Y: -1}, math.Sqrt2},
+}
+
+// FindPath returns the cells of a shortest path from cell from to cell to, both included, or nil if
+// there's none. Diagonal steps never cut the corner of a blocked cell. Ends that are blocked, e.g. a
+// character pushed into an obstacle, are moved to the nearest walkable cell.
+func (g *WalkGrid) FindPath(from image.Point, to image.Point) []image.Point {
+	var ok bool
+	if from, ok = g.Nearest(from, 2); !ok {
+		return nil
+	}
+	if to, ok = g.Nearest(to, 4); !ok {
+		return nil
+	}
+	if from == to {
+		return []image.Point{to}
+	}
+
+	index := func(c image.Point) int { return c.Y*g.Width + c.X }
+	cost := make([]float64, g.Width*g.Height)
+	for i := range cost {
+		cost[i] = math.Inf(1)
+	}
+	came := make([]image.Point, g.Width*g.Height)
+	open := &pathQueue{}
+	cost[index(from)] = 0
+	heap.Push(open, pathNode{cell: from, priority: octile(from, to)})
+
+	for open.Len() > 0 {
+		node := heap.Pop(open).(pathNode)
+		cur := node.cell
+		if cur == to {
+			path := []image.Point{cur}
+			for cur != from {
+				cur = came[index(cur)]
+				path = append(path, cur)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		if node.priority > cost[index(cur)]+octile(cur, to)+1e-9 {
+			// stale, already reached more cheaply
+			continue
+		}
+		for _, step := range walkSteps {
+			next := cur.Add(step.d)
+			if !g.Walkable(next) {
+				continue
+			}
+			if step.d.X != 0 && step.d.Y != 0 && (!g.Walkable(image.Point{X: next.X, Y: cur.Y}) || !g.Walkable(image.Point{X: cur.X, Y: next.Y})) {
+				continue
+			}
+			c := cost[index(cur)] + step.cost
+			if cost[index(next)] <= c {
+				continue
+			}
+			cost[index(next)] = c
+			came[index(next)] = cur
+			heap.Push(open, pathNode{cell: next, priority: c + octile(next, to)})
+		}
+	}
+	return nil
+}
+
+// Smooth drops the cells of path a character can skip, walking straight from the last kept cell to
+// the one after. The ends are kept.
+func (g *WalkGrid) Smooth(path []image.Point) []image.Point {
+	if len(path) < 3 {
+		return path
+	}
+	smooth := []image.Point{path[0]}
+	for i := 1; i < len(path)-1; i++ {
+		if !g.Clear(smooth[len(smooth)-1], path[i+1]) {
+			smooth = append(smooth, path[i])
+		}
+	}
+	return append(smooth, path[len(path)-1])
+}
+
+// Clear reports whether the straight line between the centers of cells a and b only crosses
+// walkable cells, sampled every quarter cell.
+func (g *WalkGrid) Clear(a image.Point, b image.Point) bool {
+	from, to := g.Center(a), g.Center(b)
+	steps := max(abs(to.X-from.X), abs(to.Y-from.Y))*4/g.CellSize + 1
+	for i := 0; i <= steps; i++ {
+		p := image.Point{X: from.X + (to.X-from.X)*i/steps, Y: from.Y + (to.Y-from.Y)*i/steps}
+		// both sides of a step that runs along a cell edge
+		if !g.Walkable(g.CellAt(p)) || !g.Walkable(g.CellAt(p.Sub(image.Point{X: 1, Y: 1}))) {
+			return false
+		}
+	}
+	return true
+}
+
+// octile is the length of the shortest 8 way path between a and b on an open grid.
+func octile(a image.Point, b image.Point) float64 {
+	dx, dy := float64(abs(a.X-b.X)), float64(abs(a.Y-b.Y))
+	return dx + dy + (math.Sqrt2-2)*math.Min(dx, dy)
+}
+
+type pathNode struct {
+	cell     image.Point
+	priority float64 // cost so far plus the estimate left
+}
+
+// pathQueue is a min heap of nodes by priority, see container/heap.
+type pathQueue []pathNode
+
+func (q pathQueue) Len() int           { return len(q) }
+func (q pathQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q pathQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x any)        { *q = append(*q, x.(pathNode)) }
+func (q *pathQueue) Pop() any          { n := (*q)[len(*q)-1]; *q = (*q)[:len(*q)-1]; return n }
+
+func floorDiv(a int, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+/*
+This file contains steering behaviours for crowds: each returns a desired direction, at most unit length,
+for callers to weigh and add up.
+*/
+package model
+
+// Seek heads straight for target.
+func Seek(pos Vec2, target Vec2) *Vec2 {
+	return target.Sub(&pos).Norm()
+}
+
+// Arrive heads for target like Seek, slowing down within slowRadius of it to stop on it.
+func Arrive(pos Vec2, target Vec2, slowRadius float32) *Vec2 {
+	d := pos.Distance(&target)
+	if d >= slowRadius {
+		return Seek(pos, target)
+	}
+	return Seek(pos, target).Mul(d / slowRadius)
+}
+
+// Separation pushes away from the neighbours closer than radius, the harder the closer they are.
+// Neighbours on the same spot push toward unstack, which should differ between agents so a stack comes apart.
+func Separation(pos Vec2, neighbours []Vec2, radius float32, unstack *Vec2) *Vec2 {
+	push := &Vec2{}
+	for i := range neighbours {
+		d := pos.Distance(&neighbours[i])
+		if d >= radius {
+			continue
+		}
+		away := pos.Sub(&neighbours[i]).Norm()
+		if d == 0 {
+			away = unstack
+		}
+		push = push.Add(away.Mul(1 - d/radius))
+	}
+	if push.Length() > 1 {
+		return push.Norm()
+	}
+	return push
+}
+
+// Cohesion pulls toward the middle of the neighbours, full strength radius px away from it.
+func Cohesion(pos Vec2, neighbours []Vec2, radius float32) *Vec2 {
+	if len(neighbours) == 0 {
+		return &Vec2{}
+	}
+	center := &Vec2{}
+	for i := range neighbours {
+		center = center.Add(&neighbours[i])
+	}
+	return Arrive(pos, *center.Mul(1 / float32(len(neighbours))), radius)
+}
@@ -0,0 +1,901 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// TiledMap is a map exported from the Tiled editor (https://www.mapeditor.org), as JSON (.tmj) or TMX.
+// Only orthogonal, finite maps are supported. Group layers are flattened into their children, which
+// inherit the group's visibility, opacity and properties.
+type TiledMap struct {
+	Width      int // in tiles
+	Height     int
+	TileWidth  int
+	TileHeight int
+	Layers     []*TiledLayer
+	Tilesets   []*TiledTileset
+	Properties TiledProperties
+
+	Dir string // directory the map was loaded from, image and tileset paths are relative to it
+}
+
+const (
+	TiledTileLayer   = "tilelayer"
+	TiledObjectGroup = "objectgroup"
+)
+
+type TiledLayer struct {
+	Name       string
+	Type       string // TiledTileLayer or TiledObjectGroup
+	Visible    bool
+	Opacity    float32
+	Tiles      []uint32 // global tile ids, row by row, 0 is empty. Flip flags are cleared
+	Objects    []*TiledObject
+	Properties TiledProperties
+}
+
+type TiledObject struct {
+	ID         int
+	Name       string
+	Class      string // "type" before Tiled 1.9
+	X          float32
+	Y          float32
+	Width      float32
+	Height     float32
+	GID        uint32 // tile objects only, drawn with their bottom left at (X, Y)
+	Point      bool
+	Ellipse    bool   // inside (X, Y, Width, Height)
+	Points     []Vec2 // polygon or polyline vertices, relative to (X, Y)
+	Properties TiledProperties
+}
+
+type TiledTileset struct {
+	FirstGID   uint32
+	Source     string // external tileset file, relative to the map. Empty once resolved
+	Path       string // the external file once resolved, joined with the map's directory
+	Name       string
+	TileWidth  int
+	TileHeight int
+	TileCount  int
+	Columns    int
+	Margin     int
+	Spacing    int
+	Image      string // single image tilesets, relative to Dir
+	Tiles      map[int]*TiledTile
+	Dir        string // directory of the tileset file
+}
+
+// TiledTile holds per tile data: its own image in image collection tilesets, and properties.
+type TiledTile struct {
+	ID         int
+	Image      string // relative to the tileset's Dir
+	Width      int
+	Height     int
+	Class      string
+	Properties TiledProperties
+	Collision  []*TiledObject // shapes from Tiled's tile collision editor, relative to the image
+}
+
+// TiledProperties are custom properties, values kept as Tiled wrote them.
+type TiledProperties map[string]string
+
+func (p TiledProperties) Bool(name string) bool {
+	b, _ := strconv.ParseBool(p[name])
+	return b
+}
+
+// BoolOr is the bool property name, or fallback when it isn't set.
+func (p TiledProperties) BoolOr(name string, fallback bool) bool {
+	b, err := strconv.ParseBool(p[name])
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
+func (p TiledProperties) Float(name string, fallback float32) float32 {
+	f, err := strconv.ParseFloat(p[name], 32)
+	if err != nil {
+		return fallback
+	}
+	return float32(f)
+}
+
+// Tiled stores flips and rotation in the top bits of a gid
+const tiledFlipMask = 0xF0000000
+
+// ParseTiledMap decodes a map. TMX is detected by its leading '<', anything else is read as JSON.
+// External tilesets are left unresolved, see ResolveTilesets.
+func ParseTiledMap(data []byte, dir string) (*TiledMap, error) {
+	var m *TiledMap
+	var err error
+	if isXML(data) {
+		m, err = parseTMX(data)
+	} else {
+		m, err = parseTiledJSON(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Dir = dir
+	for _, ts := range m.Tilesets {
+		if ts.Source == "" {
+			ts.Dir = dir
+		}
+	}
+	for _, layer := range m.Layers {
+		if layer.Type == TiledTileLayer && len(layer.Tiles) != m.Width*m.Height {
+			return nil, fmt.Errorf("layer %q has %d tiles, the map is %dx%d", layer.Name, len(layer.Tiles), m.Width, m.Height)
+		}
+	}
+	return m, nil
+}
+
+// ResolveTilesets loads external tilesets (.tsj/.json or .tsx) with read, which is given paths
+// joined with the map's directory.
+func (m *TiledMap) ResolveTilesets(read func(path string) ([]byte, error)) error {
+	for i, ts := range m.Tilesets {
+		if ts.Source == "" {
+			continue
+		}
+		p := path.Join(m.Dir, ts.Source)
+		data, err := read(p)
+		if err != nil {
+			return fmt.Errorf("tileset %s: %w", p, err)
+		}
+		var loaded *TiledTileset
+		if isXML(data) {
+			loaded, err = parseTSX(data)
+		} else {
+			loaded, err = parseTilesetJSON(data)
+		}
+		if err != nil {
+			return fmt.Errorf("tileset %s: %w", p, err)
+		}
+		loaded.FirstGID = ts.FirstGID
+		loaded.Path = p
+		loaded.Dir = path.Dir(p)
+		m.Tilesets[i] = loaded
+	}
+	return nil
+}
+
+// TilesetFor returns the tileset gid belongs to and the tile's id within it. Nil for 0 or an unknown gid.
+func (m *TiledMap) TilesetFor(gid uint32) (*TiledTileset, int) {
+	gid &^= tiledFlipMask
+	if gid == 0 {
+		return nil, 0
+	}
+	var found *TiledTileset
+	for _, ts := range m.Tilesets {
+		if ts.FirstGID <= gid && (found == nil || ts.FirstGID > found.FirstGID) {
+			found = ts
+		}
+	}
+	if found == nil {
+		return nil, 0
+	}
+	return found, int(gid - found.FirstGID)
+}
+
+// TilesetAt returns the tileset resolved from the file at p, or nil.
+func (m *TiledMap) TilesetAt(p string) *TiledTileset {
+	for _, ts := range m.Tilesets {
+		if ts.Path != "" && ts.Path == path.Clean(p) {
+			return ts
+		}
+	}
+	return nil
+}
+
+// Layer returns the first layer called name (case insensitive), or nil.
+func (m *TiledMap) Layer(name string) *TiledLayer {
+	for _, layer := range m.Layers {
+		if strings.EqualFold(layer.Name, name) {
+			return layer
+		}
+	}
+	return nil
+}
+
+// IsCollision reports whether a layer holds collision rather than graphics: it's called "collision"
+// or has a true "collision" property. Its tiles, or object rectangles, are solid.
+func (l *TiledLayer) IsCollision() bool {
+	return strings.EqualFold(l.Name, "collision") || l.Properties.Bool("collision")
+}
+
+// Tile returns the gid at (x, y), 0 if empty or outside the layer.
+func (l *TiledLayer) Tile(m *TiledMap, x int, y int) uint32 {
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height || l.Type != TiledTileLayer {
+		return 0
+	}
+	return l.Tiles[y*m.Width+x]
+}
+
+// Collider is a static obstacle, in map pixels.
+type Collider struct {
+	Rect              image.Rectangle
+	BlocksProjectiles bool   // tall obstacles stop projectiles, low ones (stones, logs, fences) are shot over
+	Class             string // e.g. "tree", or the collision layer's object class
+}
+
+// Colliders lists the map's obstacles: every tile of a collision tile layer, every shape of a collision
+// object layer, and every prop whose tile has a true "solid" property. A prop collides with its tile's
+// collision shapes, or the lower half of its image when it has none. Ellipses, polygons and polylines
+// collide as their bounding box, points and shapes with no area don't collide. "blocksProjectiles"
+// properties (on the prop's tile, the object or the layer) decide if projectiles stop; collision
+// layers stop them unless told otherwise, props don't.
+func (m *TiledMap) Colliders() []Collider {
+	var colliders []Collider
+	for _, layer := range m.Layers {
+		switch {
+		case layer.IsCollision() && layer.Type == TiledTileLayer:
+			blocks := layer.Properties.BoolOr("blocksProjectiles", true)
+			for i, gid := range layer.Tiles {
+				if gid == 0 {
+					continue
+				}
+				x, y := (i%m.Width)*m.TileWidth, (i/m.Width)*m.TileHeight
+				colliders = append(colliders, Collider{Rect: image.Rect(x, y, x+m.TileWidth, y+m.TileHeight), BlocksProjectiles: blocks})
+			}
+		case layer.IsCollision():
+			for _, obj := range layer.Objects {
+				if obj.Rect().Empty() {
+					// points, and straight lines along an axis
+					continue
+				}
+				blocks := obj.Properties.BoolOr("blocksProjectiles", layer.Properties.BoolOr("blocksProjectiles", true))
+				colliders = append(colliders, Collider{Rect: obj.Rect(), BlocksProjectiles: blocks, Class: obj.Class})
+			}
+		default:
+			for _, obj := range layer.Objects {
+				colliders = append(colliders, m.propColliders(obj)...)
+			}
+		}
+	}
+	return colliders
+}
+
+// propColliders are the colliders of a solid tile object, scaled with it when it was resized.
+func (m *TiledMap) propColliders(obj *TiledObject) []Collider {
+	ts, id := m.TilesetFor(obj.GID)
+	if ts == nil {
+		return nil
+	}
+	tile, ok := ts.Tiles[id]
+	if !ok || !tile.Properties.Bool("solid") {
+		return nil
+	}
+	class := obj.Class
+	if class == "" {
+		class = tile.Class
+	}
+	blocks := obj.Properties.BoolOr("blocksProjectiles", tile.Properties.Bool("blocksProjectiles"))
+
+	w, h := tile.Width, tile.Height
+	if w == 0 || h == 0 {
+		w, h = ts.TileWidth, ts.TileHeight
+	}
+	shapes := []image.Rectangle{image.Rect(0, h/2, w, h)}
+	if len(tile.Collision) > 0 {
+		shapes = shapes[:0]
+		for _, shape := range tile.Collision {
+			if !shape.Rect().Empty() {
+				shapes = append(shapes, shape.Rect())
+			}
+		}
+	}
+
+	r := obj.Rect()
+	sx, sy := float32(r.Dx())/float32(w), float32(r.Dy())/float32(h)
+	var colliders []Collider
+	for _, s := range shapes {
+		scaled := image.Rect(int(float32(s.Min.X)*sx), int(float32(s.Min.Y)*sy), int(float32(s.Max.X)*sx), int(float32(s.Max.Y)*sy))
+		colliders = append(colliders, Collider{Rect: scaled.Add(r.Min), BlocksProjectiles: blocks, Class: class})
+	}
+	return colliders
+}
+
+// Rect is the area the object covers, in map pixels. Tile objects sit on their (X, Y), polygons and
+// polylines cover the bounding box of their points, and points cover nothing.
+func (o *TiledObject) Rect() image.Rectangle {
+	if len(o.Points) > 0 {
+		lo, hi := o.Points[0], o.Points[0]
+		for _, p := range o.Points[1:] {
+			lo.X, lo.Y = min(lo.X, p.X), min(lo.Y, p.Y)
+			hi.X, hi.Y = max(hi.X, p.X), max(hi.Y, p.Y)
+		}
+		return image.Rect(
+			int(math.Floor(float64(o.X+lo.X))), int(math.Floor(float64(o.Y+lo.Y))),
+			int(math.Ceil(float64(o.X+hi.X))), int(math.Ceil(float64(o.Y+hi.Y))),
+		)
+	}
+	x, y := int(o.X), int(o.Y)
+	if o.GID != 0 {
+		y -= int(o.Height)
+	}
+	return image.Rect(x, y, x+int(o.Width), y+int(o.Height))
+}
+
+// TileRect is tile id's rectangle in a single image tileset.
+func (ts *TiledTileset) TileRect(id int) image.Rectangle {
+	cols := ts.Columns
+	if cols <= 0 {
+		cols = 1
+	}
+	x := ts.Margin + (id%cols)*(ts.TileWidth+ts.Spacing)
+	y := ts.Margin + (id/cols)*(ts.TileHeight+ts.Spacing)
+	return image.Rect(x, y, x+ts.TileWidth, y+ts.TileHeight)
+}
+
+// ImageOf is the image path, relative to the map's asset root, of a tile: its own image in image
+// collections, else the tileset's image.
+func (ts *TiledTileset) ImageOf(id int) string {
+	if t, ok := ts.Tiles[id]; ok && t.Image != "" {
+		return path.Join(ts.Dir, t.Image)
+	}
+	if ts.Image == "" {
+		return ""
+	}
+	return path.Join(ts.Dir, ts.Image)
+}
+
+func isXML(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("<"))
+}
+
+// -------------------- JSON --------------------
+
+type tiledPropertyJSON struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+func propertiesFromJSON(props []tiledPropertyJSON) TiledProperties {
+	if len(props) == 0 {
+		return nil
+	}
+	p := make(TiledProperties, len(props))
+	for _, prop := range props {
+		p[prop.Name] = fmt.Sprint(prop.Value)
+	}
+	return p
+}
+
+type tiledMapJSON struct {
+	Width       int                 `json:"width"`
+	Height      int                 `json:"height"`
+	TileWidth   int                 `json:"tilewidth"`
+	TileHeight  int                 `json:"tileheight"`
+	Orientation string              `json:"orientation"`
+	Infinite    bool                `json:"infinite"`
+	Layers      []tiledLayerJSON    `json:"layers"`
+	Tilesets    []tiledTilesetJSON  `json:"tilesets"`
+	Properties  []tiledPropertyJSON `json:"properties"`
+}
+
+type tiledLayerJSON struct {
+	Name        string              `json:"name"`
+	Type        string              `json:"type"`
+	Visible     bool                `json:"visible"`
+	Opacity     float32             `json:"opacity"`
+	Data        json.RawMessage     `json:"data"`
+	Encoding    string              `json:"encoding"`
+	Compression string              `json:"compression"`
+	Objects     []tiledObjectJSON   `json:"objects"`
+	Layers      []tiledLayerJSON    `json:"layers"`
+	Properties  []tiledPropertyJSON `json:"properties"`
+}
+
+type tiledObjectJSON struct {
+	ID         int                 `json:"id"`
+	Name       string              `json:"name"`
+	Type       string              `json:"type"`
+	Class      string              `json:"class"`
+	X          float32             `json:"x"`
+	Y          float32             `json:"y"`
+	Width      float32             `json:"width"`
+	Height     float32             `json:"height"`
+	GID        uint32              `json:"gid"`
+	Point      bool                `json:"point"`
+	Ellipse    bool                `json:"ellipse"`
+	Polygon    []Vec2              `json:"polygon"`
+	Polyline   []Vec2              `json:"polyline"`
+	Properties []tiledPropertyJSON `json:"properties"`
+}
+
+type tiledTilesetJSON struct {
+	FirstGID   uint32          `json:"firstgid"`
+	Source     string          `json:"source"`
+	Name       string          `json:"name"`
+	TileWidth  int             `json:"tilewidth"`
+	TileHeight int             `json:"tileheight"`
+	TileCount  int             `json:"tilecount"`
+	Columns    int             `json:"columns"`
+	Margin     int             `json:"margin"`
+	Spacing    int             `json:"spacing"`
+	Image      string          `json:"image"`
+	Tiles      []tiledTileJSON `json:"tiles"`
+}
+
+type tiledTileJSON struct {
+	ID          int                 `json:"id"`
+	Image       string              `json:"image"`
+	ImageWidth  int                 `json:"imagewidth"`
+	ImageHeight int                 `json:"imageheight"`
+	Type        string              `json:"type"`
+	Class       string              `json:"class"`
+	Properties  []tiledPropertyJSON `json:"properties"`
+	ObjectGroup *struct {
+		Objects []tiledObjectJSON `json:"objects"`
+	} `json:"objectgroup"`
+}
+
+func parseTiledJSON(data []byte) (*TiledMap, error) {
+	var raw tiledMapJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if err := checkTiledMap(raw.Orientation, raw.Infinite); err != nil {
+		return nil, err
+	}
+	m := &TiledMap{
+		Width:      raw.Width,
+		Height:     raw.Height,
+		TileWidth:  raw.TileWidth,
+		TileHeight: raw.TileHeight,
+		Properties: propertiesFromJSON(raw.Properties),
+	}
+	if err := m.addJSONLayers(raw.Layers, nil); err != nil {
+		return nil, err
+	}
+	for _, ts := range raw.Tilesets {
+		m.Tilesets = append(m.Tilesets, tilesetFromJSON(ts))
+	}
+	return m, nil
+}
+
+// addJSONLayers adds layers, flattening groups. group is the one they're in, nil at the top.
+func (m *TiledMap) addJSONLayers(layers []tiledLayerJSON, group *TiledLayer) error {
+	for _, raw := range layers {
+		layer := &TiledLayer{
+			Name:       raw.Name,
+			Type:       raw.Type,
+			Visible:    raw.Visible,
+			Opacity:    raw.Opacity,
+			Properties: propertiesFromJSON(raw.Properties),
+		}
+		layer.inherit(group)
+		switch raw.Type {
+		case "group":
+			if err := m.addJSONLayers(raw.Layers, layer); err != nil {
+				return err
+			}
+			continue
+		case TiledTileLayer:
+			tiles, err := decodeJSONTiles(raw)
+			if err != nil {
+				return fmt.Errorf("layer %q: %w", raw.Name, err)
+			}
+			layer.Tiles = tiles
+		case TiledObjectGroup:
+			for _, o := range raw.Objects {
+				layer.Objects = append(layer.Objects, objectFromJSON(o))
+			}
+		default:
+			// image layers aren't supported
+			continue
+		}
+		m.Layers = append(m.Layers, layer)
+	}
+	return nil
+}
+
+// inherit applies the group a layer is in, nil if none: the layer shows only if the group does, the
+// opacities multiply, and the group's properties apply unless the layer sets its own.
+func (l *TiledLayer) inherit(group *TiledLayer) {
+	if group == nil {
+		return
+	}
+	l.Visible = l.Visible && group.Visible
+	l.Opacity *= group.Opacity
+	for name, value := range group.Properties {
+		if _, ok := l.Properties[name]; ok {
+			continue
+		}
+		if l.Properties == nil {
+			l.Properties = make(TiledProperties, len(group.Properties))
+		}
+		l.Properties[name] = value
+	}
+}
+
+func objectFromJSON(o tiledObjectJSON) *TiledObject {
+	class := o.Class
+	if class == "" {
+		class = o.Type
+	}
+	return &TiledObject{
+		ID: o.ID, Name: o.Name, Class: class,
+		X: o.X, Y: o.Y, Width: o.Width, Height: o.Height,
+		GID: o.GID &^ tiledFlipMask, Point: o.Point, Ellipse: o.Ellipse, Points: append(o.Polygon, o.Polyline...),
+		Properties: propertiesFromJSON(o.Properties),
+	}
+}
+
+func decodeJSONTiles(raw tiledLayerJSON) ([]uint32, error) {
+	if raw.Encoding == "base64" {
+		var s string
+		if err := json.Unmarshal(raw.Data, &s); err != nil {
+			return nil, err
+		}
+		return decodeBase64Tiles(s, raw.Compression)
+	}
+	var tiles []uint32
+	if err := json.Unmarshal(raw.Data, &tiles); err != nil {
+		return nil, err
+	}
+	for i := range tiles {
+		tiles[i] &^= tiledFlipMask
+	}
+	return tiles, nil
+}
+
+func parseTilesetJSON(data []byte) (*TiledTileset, error) {
+	var raw tiledTilesetJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return tilesetFromJSON(raw), nil
+}
+
+func tilesetFromJSON(raw tiledTilesetJSON) *TiledTileset {
+	ts := &TiledTileset{
+		FirstGID: raw.FirstGID, Source: raw.Source, Name: raw.Name,
+		TileWidth: raw.TileWidth, TileHeight: raw.TileHeight, TileCount: raw.TileCount,
+		Columns: raw.Columns, Margin: raw.Margin, Spacing: raw.Spacing, Image: raw.Image,
+		Tiles: make(map[int]*TiledTile),
+	}
+	for _, t := range raw.Tiles {
+		class := t.Class
+		if class == "" {
+			class = t.Type
+		}
+		tile := &TiledTile{
+			ID: t.ID, Image: t.Image, Width: t.ImageWidth, Height: t.ImageHeight,
+			Class: class, Properties: propertiesFromJSON(t.Properties),
+		}
+		if t.ObjectGroup != nil {
+			for _, o := range t.ObjectGroup.Objects {
+				tile.Collision = append(tile.Collision, objectFromJSON(o))
+			}
+		}
+		ts.Tiles[t.ID] = tile
+	}
+	return ts
+}
+
+// -------------------- TMX --------------------
+
+type tmxProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+	Text  string `xml:",chardata"` // multi-line strings
+}
+
+func propertiesFromTMX(props []tmxProperty) TiledProperties {
+	if len(props) == 0 {
+		return nil
+	}
+	p := make(TiledProperties, len(props))
+	for _, prop := range props {
+		if prop.Value == "" {
+			p[prop.Name] = prop.Text
+		} else {
+			p[prop.Name] = prop.Value
+		}
+	}
+	return p
+}
+
+type tmxMap struct {
+	Width       int           `xml:"width,attr"`
+	Height      int           `xml:"height,attr"`
+	TileWidth   int           `xml:"tilewidth,attr"`
+	TileHeight  int           `xml:"tileheight,attr"`
+	Orientation string        `xml:"orientation,attr"`
+	Infinite    bool          `xml:"infinite,attr"`
+	Tilesets    []tmxTileset  `xml:"tileset"`
+	Layers      []tmxAnyLayer `xml:",any"`
+	Properties  []tmxProperty `xml:"properties>property"`
+}
+
+// tmxAnyLayer is a layer, objectgroup or group, kept in document order
+type tmxAnyLayer struct {
+	XMLName    xml.Name
+	Name       string        `xml:"name,attr"`
+	Visible    *int          `xml:"visible,attr"`
+	Opacity    *float32      `xml:"opacity,attr"`
+	Data       tmxData       `xml:"data"`
+	Objects    []tmxObject   `xml:"object"`
+	Layers     []tmxAnyLayer `xml:",any"`
+	Properties []tmxProperty `xml:"properties>property"`
+}
+
+type tmxData struct {
+	Encoding    string `xml:"encoding,attr"`
+	Compression string `xml:"compression,attr"`
+	Text        string `xml:",chardata"`
+	Tiles       []struct {
+		GID uint32 `xml:"gid,attr"`
+	} `xml:"tile"`
+}
+
+type tmxObject struct {
+	ID         int           `xml:"id,attr"`
+	Name       string        `xml:"name,attr"`
+	Type       string        `xml:"type,attr"`
+	Class      string        `xml:"class,attr"`
+	X          float32       `xml:"x,attr"`
+	Y          float32       `xml:"y,attr"`
+	Width      float32       `xml:"width,attr"`
+	Height     float32       `xml:"height,attr"`
+	GID        uint32        `xml:"gid,attr"`
+	Point      *struct{}     `xml:"point"`
+	Ellipse    *struct{}     `xml:"ellipse"`
+	Polygon    tmxShape      `xml:"polygon"`
+	Polyline   tmxShape      `xml:"polyline"`
+	Properties []tmxProperty `xml:"properties>property"`
+}
+
+type tmxShape struct {
+	Points tmxPoints `xml:"points,attr"`
+}
+
+// tmxPoints are the vertices of a polygon or polyline, written "x,y x,y ..."
+type tmxPoints []Vec2
+
+func (p *tmxPoints) UnmarshalText(text []byte) error {
+	for _, pair := range strings.Fields(string(text)) {
+		var v Vec2
+		if _, err := fmt.Sscanf(pair, "%g,%g", &v.X, &v.Y); err != nil {
+			return fmt.Errorf("point %q: %w", pair, err)
+		}
+		*p = append(*p, v)
+	}
+	return nil
+}
+
+type tmxImage struct {
+	Source string `xml:"source,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
+
+type tmxTileset struct {
+	FirstGID   uint32   `xml:"firstgid,attr"`
+	Source     string   `xml:"source,attr"`
+	Name       string   `xml:"name,attr"`
+	TileWidth  int      `xml:"tilewidth,attr"`
+	TileHeight int      `xml:"tileheight,attr"`
+	TileCount  int      `xml:"tilecount,attr"`
+	Columns    int      `xml:"columns,attr"`
+	Margin     int      `xml:"margin,attr"`
+	Spacing    int      `xml:"spacing,attr"`
+	Image      tmxImage `xml:"image"`
+	Tiles      []struct {
+		ID         int           `xml:"id,attr"`
+		Type       string        `xml:"type,attr"`
+		Class      string        `xml:"class,attr"`
+		Image      tmxImage      `xml:"image"`
+		Properties []tmxProperty `xml:"properties>property"`
+		Collision  []tmxObject   `xml:"objectgroup>object"`
+	} `xml:"tile"`
+}
+
+func parseTMX(data []byte) (*TiledMap, error) {
+	var raw tmxMap
+	if err := xml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if err := checkTiledMap(raw.Orientation, raw.Infinite); err != nil {
+		return nil, err
+	}
+	m := &TiledMap{
+		Width:      raw.Width,
+		Height:     raw.Height,
+		TileWidth:  raw.TileWidth,
+		TileHeight: raw.TileHeight,
+		Properties: propertiesFromTMX(raw.Properties),
+	}
+	if err := m.addTMXLayers(raw.Layers, nil); err != nil {
+		return nil, err
+	}
+	for _, ts := range raw.Tilesets {
+		m.Tilesets = append(m.Tilesets, tilesetFromTMX(ts))
+	}
+	return m, nil
+}
+
+// addTMXLayers adds layers, flattening groups. group is the one they're in, nil at the top.
+func (m *TiledMap) addTMXLayers(layers []tmxAnyLayer, group *TiledLayer) error {
+	for _, raw := range layers {
+		layer := &TiledLayer{
+			Name:       raw.Name,
+			Visible:    raw.Visible == nil || *raw.Visible != 0,
+			Opacity:    1,
+			Properties: propertiesFromTMX(raw.Properties),
+		}
+		if raw.Opacity != nil {
+			layer.Opacity = *raw.Opacity
+		}
+		layer.inherit(group)
+		switch raw.XMLName.Local {
+		case "group":
+			if err := m.addTMXLayers(raw.Layers, layer); err != nil {
+				return err
+			}
+			continue
+		case "layer":
+			layer.Type = TiledTileLayer
+			tiles, err := decodeTMXTiles(raw.Data)
+			if err != nil {
+				return fmt.Errorf("layer %q: %w", raw.Name, err)
+			}
+			layer.Tiles = tiles
+		case "objectgroup":
+			layer.Type = TiledObjectGroup
+			for _, o := range raw.Objects {
+				layer.Objects = append(layer.Objects, objectFromTMX(o))
+			}
+		default:
+			// tilesets, properties, image layers...
+			continue
+		}
+		m.Layers = append(m.Layers, layer)
+	}
+	return nil
+}
+
+func objectFromTMX(o tmxObject) *TiledObject {
+	class := o.Class
+	if class == "" {
+		class = o.Type
+	}
+	return &TiledObject{
+		ID: o.ID, Name: o.Name, Class: class,
+		X: o.X, Y: o.Y, Width: o.Width, Height: o.Height,
+		GID: o.GID &^ tiledFlipMask, Point: o.Point != nil, Ellipse: o.Ellipse != nil, Points: append(o.Polygon.Points, o.Polyline.Points...),
+		Properties: propertiesFromTMX(o.Properties),
+	}
+}
+
+func decodeTMXTiles(data tmxData) ([]uint32, error) {
+	switch data.Encoding {
+	case "csv":
+		r := csv.NewReader(strings.NewReader(strings.TrimSpace(data.Text)))
+		r.FieldsPerRecord = -1 // rows end with a trailing comma
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		var tiles []uint32
+		for _, record := range records {
+			for _, field := range record {
+				field = strings.TrimSpace(field)
+				if field == "" {
+					continue
+				}
+				gid, err := strconv.ParseUint(field, 10, 32)
+				if err != nil {
+					return nil, err
+				}
+				tiles = append(tiles, uint32(gid)&^tiledFlipMask)
+			}
+		}
+		return tiles, nil
+	case "base64":
+		return decodeBase64Tiles(data.Text, data.Compression)
+	case "":
+		// deprecated <tile gid=""/> elements
+		tiles := make([]uint32, len(data.Tiles))
+		for i, t := range data.Tiles {
+			tiles[i] = t.GID &^ tiledFlipMask
+		}
+		return tiles, nil
+	}
+	return nil, fmt.Errorf("unsupported tile encoding %q", data.Encoding)
+}
+
+func parseTSX(data []byte) (*TiledTileset, error) {
+	var raw tmxTileset
+	if err := xml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return tilesetFromTMX(raw), nil
+}
+
+func tilesetFromTMX(raw tmxTileset) *TiledTileset {
+	ts := &TiledTileset{
+		FirstGID: raw.FirstGID, Source: raw.Source, Name: raw.Name,
+		TileWidth: raw.TileWidth, TileHeight: raw.TileHeight, TileCount: raw.TileCount,
+		Columns: raw.Columns, Margin: raw.Margin, Spacing: raw.Spacing, Image: raw.Image.Source,
+		Tiles: make(map[int]*TiledTile),
+	}
+	for _, t := range raw.Tiles {
+		class := t.Class
+		if class == "" {
+			class = t.Type
+		}
+		tile := &TiledTile{
+			ID: t.ID, Image: t.Image.Source, Width: t.Image.Width, Height: t.Image.Height,
+			Class: class, Properties: propertiesFromTMX(t.Properties),
+		}
+		for _, o := range t.Collision {
+			tile.Collision = append(tile.Collision, objectFromTMX(o))
+		}
+		ts.Tiles[t.ID] = tile
+	}
+	return ts
+}
+
+// -------------------- shared --------------------
+
+func checkTiledMap(orientation string, infinite bool) error {
+	if orientation != "" && orientation != "orthogonal" {
+		return fmt.Errorf("%s maps aren't supported, only orthogonal", orientation)
+	}
+	if infinite {
+		return errors.New("infinite maps aren't supported, uncheck Infinite in the map properties")
+	}
+	return nil
+}
+
+func decodeBase64Tiles(s string, compression string) ([]uint32, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = bytes.NewReader(raw)
+	switch compression {
+	case "":
+	case "zlib":
+		if r, err = zlib.NewReader(r); err != nil {
+			return nil, err
+		}
+	case "gzip":
+		if r, err = gzip.NewReader(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q, use zlib, gzip or none", compression)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("tile data is %d bytes, not a multiple of 4", len(data))
+	}
+	tiles := make([]uint32, len(data)/4)
+	for i := range tiles {
+		tiles[i] = binary.LittleEndian.Uint32(data[i*4:]) &^ tiledFlipMask
+	}
+	return tiles, nil
+}
@@ -16,6 +16,10 @@ func (v *Vec2) Norm() *Vec2 {
 func (v *Vec2) Add(u *Vec2) *Vec2   { return &Vec2{v.X + u.X, v.Y + u.Y} }
 func (v *Vec2) Sub(u *Vec2) *Vec2   { return &Vec2{v.X - u.X, v.Y - u.Y} }
 func (v *Vec2) Mul(s float32) *Vec2 { return &Vec2{v.X * s, v.Y * s} }
+func (v *Vec2) Rotate(radians float64) *Vec2 {
+	sin, cos := math.Sincos(radians)
+	return &Vec2{X: v.X*float32(cos) - v.Y*float32(sin), Y: v.X*float32(sin) + v.Y*float32(cos)}
+}
 func (v *Vec2) Distance(u *Vec2) float32 {
 	return float32(math.Hypot(float64(v.X-u.X), float64(v.Y-u.Y)))
 }
@@ -1,36 +1,142 @@
 package scripts
 
 import (
+	"fmt"
+	"game/fsm"
+	"game/model"
 	"image"
-	"log"
+	"io/fs"
 	"math"
+	pathpkg "path"
 	"strconv"
 	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 )
 
 type AnimationState int
 
+// frames without their own timing (e.g. fps missing from the manifest) advance at this rate
+const defaultFrameDuration = 150 * time.Millisecond
+
+// animFrame is the data of every animation frame state. Clip states (the parents of frames) have none.
+type animFrame struct {
+	Image    *ebiten.Image
+	Duration time.Duration
+	Clip     string
+	Index    int  // column within the clip
+	Once     bool // last frame of a play-once clip, leaving it ends the clip
+	Events   []string
+	Layer    *model.AnimationLayer // body layer the clip is masked to, nil for the whole frame
+}
+
+// AnimationEvent is sent to subscribers when a clip reaches a frame with events on it.
+type AnimationEvent struct {
+	Name  string
+	Clip  string
+	Frame int
+}
+
+// state is an animation frame (or, as a parent, a clip facing one direction)
+type state = fsm.State[*animFrame]
+
 type WalkingAnimationManager struct {
-	curState      *state
-	overrideState *state
-	timeInState   time.Duration
+	base        *fsm.Machine[*animFrame] // locomotion clip (walk)
+	override    *fsm.Machine[*animFrame] // clip played over it (block, strife...), nil when there is none
+	timeInState time.Duration            // in the base clip's frame
+	// in the override clip's frame, the base clip keeps its own time to carry on under layered overrides
+	overrideTime time.Duration
+	// a play-once override that already finished, it won't restart until its input is released
+	finishedOverride string
+	// clip started by Play, shown until it ends even once its input is gone
+	playing     string
+	subscribers map[string][]func(AnimationEvent)
+
+	baseClip  string
+	idleClip  string        // "" if the character has no idle clip
+	FrameSize int           // size of the manifest's standard frames, oversize clips are drawn centered on it
+	composite *ebiten.Image // base and layered override frames drawn together
+
+	facings []string // directions the base clip has, 4 or 8
+	facing  string
+	// how far (radians) the aim must go past the edge of the facing's sector to turn, so aiming
+	// near a diagonal doesn't flicker between two facings
+	FacingHysteresis float64
+}
+
+const defaultFacingHysteresis = math.Pi / 15 // 12 degrees
+
+// facingFor picks the facing closest to (dirX, dirY), staying on current while the aim is within
+// hysteresis of its sector. A zero vector keeps the current facing.
+func facingFor(dirX, dirY float64, current string, facings []string, hysteresis float64) string {
+	if dirX == 0 && dirY == 0 {
+		return current
+	}
+	aim := math.Atan2(dirY, dirX)
+	if a, ok := model.DirectionAngle(current); ok {
+		halfSector := math.Pi / float64(len(facings))
+		if angleBetween(aim, a) <= halfSector+hysteresis {
+			return current
+		}
+	}
+	best, bestDist := current, math.Inf(1)
+	for _, f := range facings {
+		a, _ := model.DirectionAngle(f)
+		if d := angleBetween(aim, a); d < bestDist {
+			best, bestDist = f, d
+		}
+	}
+	return best
 }
 
-func loadDFA(spritSheetPath string, row int, startCol int, numCols int, width int, loop bool) *state {
+// angleBetween is the absolute difference of two angles, in [0, pi].
+func angleBetween(a float64, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 2*math.Pi)
+	if d > math.Pi {
+		d = 2*math.Pi - d
+	}
+	return d
+}
+
+// Subscribe calls fn every time a frame with the named event is shown.
+func (am *WalkingAnimationManager) Subscribe(event string, fn func(AnimationEvent)) {
+	if am.subscribers == nil {
+		am.subscribers = make(map[string][]func(AnimationEvent))
+	}
+	am.subscribers[event] = append(am.subscribers[event], fn)
+}
+
+// enter fires the events of a state that just became visible.
+func (am *WalkingAnimationManager) enter(s *state) {
+	frame := s.Data
+	for _, event := range frame.Events {
+		for _, fn := range am.subscribers[event] {
+			fn(AnimationEvent{Name: event, Clip: frame.Clip, Frame: frame.Index})
+		}
+	}
+}
+
+func frameDurationFromFPS(fps float32) time.Duration {
+	if fps <= 0 {
+		return defaultFrameDuration
+	}
+	return time.Duration(float64(time.Second) / float64(fps))
+}
+
+func loadDFA(spritSheetPath string, row int, startCol int, numCols int, width int, mode model.LoopMode, frameDuration time.Duration) (*state, error) {
 	col := startCol
 	var start *state
 	var prevState *state
+	var frames []*animFrame
 	for col-startCol < numCols {
 		rect := image.Rect(col*width, row*width, (col+1)*width, (row+1)*width)
-		spriteSheet, _, err := ebitenutil.NewImageFromFile(spritSheetPath)
+		img, err := assetManager.SubImage(spritSheetPath, rect)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		frame := spriteSheet.SubImage(rect).(*ebiten.Image)
-		curState := NewState("frame"+strconv.Itoa(col), frame)
+		frame := &animFrame{Image: img, Duration: frameDuration, Index: col - startCol}
+		frames = append(frames, frame)
+		curState := fsm.NewState("frame"+strconv.Itoa(col), frame)
 
 		if prevState != nil {
 			prevState.AddNext(curState)
@@ -42,127 +148,411 @@ func loadDFA(spritSheetPath string, row int, startCol int, numCols int, width in
 		}
 
 		prevState = curState
-		// connect last state to start for loop (if not done, will be overwritten)
-		if loop {
-			curState.AddNext(start)
-		} else {
-			curState.AddNext(curState) // stay on last frame
-		}
 		col++
 	}
 	start.AddPrev(start)
 
-	return start
+	last := prevState
+	switch mode {
+	case model.LoopModePingPong:
+		// walk back through copies of the middle frames, then wrap to the start
+		for i := len(frames) - 2; i >= 1; i-- {
+			curState := fsm.NewState("frame"+strconv.Itoa(startCol+i)+"_back", frames[i])
+			prevState.AddNext(curState)
+			curState.AddPrev(prevState)
+			prevState = curState
+		}
+		prevState.AddNext(start)
+	case model.LoopModeOnce:
+		frames[len(frames)-1].Once = true
+		last.AddNext(last)
+	case model.LoopModeHoldLast:
+		last.AddNext(last) // stay on last frame
+	default:
+		// connect last state to start for loop
+		last.AddNext(start)
+	}
+
+	return start, nil
 }
 
-func NewCharacterWalkingAnimator(spriteSheet string) *WalkingAnimationManager {
-	upDFA := loadDFA(spriteSheet, 8, 0, 9, 64, true)
-	leftDFA := loadDFA(spriteSheet, 9, 0, 9, 64, true)
-	downDFA := loadDFA(spriteSheet, 10, 0, 9, 64, true)
-	rightDFA := loadDFA(spriteSheet, 11, 0, 9, 64, true)
+var animationManifests = make(map[string]*model.AnimationManifest)
 
-	leftDFA.FullyConnectToOther(upDFA, "up")
-	downDFA.FullyConnectToOther(upDFA, "up")
-	rightDFA.FullyConnectToOther(upDFA, "up")
-	upDFA.FullyConnectToOther(leftDFA, "left")
-	downDFA.FullyConnectToOther(leftDFA, "left")
-	rightDFA.FullyConnectToOther(leftDFA, "left")
-	upDFA.FullyConnectToOther(downDFA, "down")
-	leftDFA.FullyConnectToOther(downDFA, "down")
-	rightDFA.FullyConnectToOther(downDFA, "down")
-	upDFA.FullyConnectToOther(rightDFA, "right")
-	leftDFA.FullyConnectToOther(rightDFA, "right")
-	downDFA.FullyConnectToOther(rightDFA, "right")
+// LoadAnimationManifest reads (and caches) the manifest at path. Sheets in it are relative to path's directory.
+func LoadAnimationManifest(manifestPath string) (*model.AnimationManifest, error) {
+	if m, ok := animationManifests[manifestPath]; ok {
+		return m, nil
+	}
+	data, err := fs.ReadFile(assetFS, manifestPath)
+	if err != nil {
+		return nil, &AssetError{Path: manifestPath, Err: err, Hint: missingHint(manifestPath, err)}
+	}
+	m, err := model.ParseAnimationManifest(data, pathpkg.Dir(manifestPath))
+	if err != nil {
+		return nil, &AssetError{Path: manifestPath, Err: err}
+	}
+	animationManifests[manifestPath] = m
+	return m, nil
+}
 
-	strifeLeftDFA := loadDFA(spriteSheet, 9, 1, 1, 64, false)
-	strifeRightDFA := loadDFA(spriteSheet, 11, 1, 1, 64, false)
-	strifeUpDFA := loadDFA(spriteSheet, 8, 3, 1, 64, false)
-	strifeDownDFA := loadDFA(spriteSheet, 10, 3, 1, 64, false)
+// loadClipDFA loads one direction of a clip. The frames are nested in a parent state for the clip,
+// so transitions out of the clip only need to be added once, on the parent.
+func loadClipDFA(m *model.AnimationManifest, name string, dir string) (start *state, clipState *state, err error) {
+	clip := m.Clips[name]
+	row, startCol := clip.RowFor(dir)
+	start, err = loadDFA(m.SheetOf(clip), row, startCol, clip.Frames, m.FrameSizeOf(clip), clip.Mode(), frameDurationFromFPS(clip.FPS))
+	if err != nil {
+		return nil, nil, err
+	}
+	clipState = fsm.NewState[*animFrame](name+"_"+dir, nil)
+	for it := start; ; {
+		it.SetParent(clipState)
+		it.Data.Clip = name
+		it.Data.Events = clip.EventsAt(it.Data.Index)
+		if clip.Layer != "" && m.FrameSizeOf(clip) == m.FrameSize {
+			it.Data.Layer = m.Layers[clip.Layer]
+		}
+		next := it.Next()
+		if next == start || next == it {
+			break
+		}
+		it = next
+	}
+	return start, clipState, nil
+}
 
-	// connect walk left to strife left
-	leftDFA.FullyConnectToOther(strifeLeftDFA, "strife")
-	rightDFA.FullyConnectToOther(strifeRightDFA, "strife")
-	downDFA.FullyConnectToOther(strifeDownDFA, "strife")
-	upDFA.FullyConnectToOther(strifeUpDFA, "strife")
+// NewCharacterWalkingAnimator builds an animator from the manifest at manifestPath.
+func NewCharacterWalkingAnimator(manifestPath string) (*WalkingAnimationManager, error) {
+	m, err := LoadAnimationManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	am, err := NewAnimatorFromManifest(m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestPath, err)
+	}
+	return am, nil
+}
+
+// NewAnimatorFromManifest wires the manifest's clips together: the base clip's directions are fully
+// connected by direction input ("up", "left"...), and every other clip is entered from the base
+// clip facing the same way by sending the clip's name (e.g. "block"). An idle clip is wired like
+// the base clip, and the two switch to each other on their names.
+func NewAnimatorFromManifest(m *model.AnimationManifest) (*WalkingAnimationManager, error) {
+	// the sheet's frames are cached so loading a clip per direction only decodes it once
+	baseStarts, baseClips, err := loadLocomotion(m, m.Base)
+	if err != nil {
+		return nil, err
+	}
+	locomotion := []map[string]*state{baseClips}
+	if m.Idle != "" {
+		idleStarts, idleClips, err := loadLocomotion(m, m.Idle)
+		if err != nil {
+			return nil, err
+		}
+		var idleFacings []string
+		for _, dir := range model.Directions8 {
+			if idleStarts[dir] != nil {
+				idleFacings = append(idleFacings, dir)
+			}
+		}
+		for dir, baseClip := range baseClips {
+			if idleStarts[dir] != nil {
+				baseClip.AddTransition(fsm.Input(m.Idle), idleStarts[dir])
+				idleClips[dir].AddTransition(fsm.Input(m.Base), baseStarts[dir])
+				continue
+			}
+			// e.g. a 4 direction idle under an 8 direction walk: stand facing the nearest way idle has,
+			// the horizontal one for diagonals
+			nearest := model.CardinalOf(dir)
+			if idleStarts[nearest] == nil {
+				a, _ := model.DirectionAngle(dir)
+				nearest = facingFor(math.Cos(a), math.Sin(a), "", idleFacings, 0)
+			}
+			baseClip.AddTransition(fsm.Input(m.Idle), idleStarts[nearest])
+			for from, idleClip := range idleClips {
+				if from != nearest {
+					idleClip.AddTransition(fsm.Input(dir), idleStarts[nearest])
+				}
+			}
+		}
+		locomotion = append(locomotion, idleClips)
+	}
 
-	blockUpDFA := loadDFA(spriteSheet, 4, 0, 8, 64, false)
-	blockLeftDFA := loadDFA(spriteSheet, 5, 0, 8, 64, false)
-	blockDownDFA := loadDFA(spriteSheet, 6, 0, 8, 64, false)
-	blockRightDFA := loadDFA(spriteSheet, 7, 0, 8, 64, false)
+	for _, name := range m.ClipNames() {
+		if name == m.Base || name == m.Idle {
+			continue
+		}
+		for _, dir := range model.Directions8 {
+			if baseClips[dir] == nil {
+				continue
+			}
+			start, _, err := loadClipDFA(m, name, dir)
+			if err != nil {
+				return nil, fmt.Errorf("clip %q: %w", name, err)
+			}
+			for _, clips := range locomotion {
+				if clips[dir] != nil {
+					clips[dir].AddTransition(fsm.Input(name), start)
+				}
+			}
+		}
+	}
 
-	// connect up walk to up block on "block" input
-	upDFA.FullyConnectToOther(blockUpDFA, "block")
-	leftDFA.FullyConnectToOther(blockLeftDFA, "block")
-	downDFA.FullyConnectToOther(blockDownDFA, "block")
-	rightDFA.FullyConnectToOther(blockRightDFA, "block")
+	var facings []string
+	for _, dir := range model.Directions8 {
+		if baseStarts[dir] != nil {
+			facings = append(facings, dir)
+		}
+	}
+	facing := "down"
+	if baseStarts[facing] == nil {
+		facing = facings[0]
+	}
 
-	return &WalkingAnimationManager{
-		curState: downDFA,
+	am := &WalkingAnimationManager{
+		base:             fsm.NewMachine(baseStarts[facing]),
+		facings:          facings,
+		facing:           facing,
+		FacingHysteresis: defaultFacingHysteresis,
+		baseClip:         m.Base,
+		idleClip:         m.Idle,
+		FrameSize:        m.FrameSize,
 	}
+	am.base.OnTransition = am.onTransition
+	return am, nil
 }
 
-func (am *WalkingAnimationManager) GetCurrentFrame() *ebiten.Image {
-	if am.overrideState != nil {
-		return am.overrideState.stateData.(*ebiten.Image)
+// loadLocomotion loads every direction of a clip the character moves (or stands) in, and lets each
+// direction turn to any other.
+func loadLocomotion(m *model.AnimationManifest, name string) (starts map[string]*state, clips map[string]*state, err error) {
+	starts = make(map[string]*state)
+	clips = make(map[string]*state)
+	for _, dir := range model.Directions8 {
+		if _, ok := m.Clips[name].Directions[dir]; !ok {
+			continue
+		}
+		start, clipState, err := loadClipDFA(m, name, dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("clip %q: %w", name, err)
+		}
+		starts[dir] = start
+		clips[dir] = clipState
 	}
 
-	if am.curState != nil {
-		return am.curState.stateData.(*ebiten.Image)
+	for from, fromClip := range clips {
+		for to, toStart := range starts {
+			if from != to {
+				fromClip.AddTransition(fsm.Input(to), toStart)
+			}
+		}
+	}
+	return starts, clips, nil
+}
+
+func (am *WalkingAnimationManager) onTransition(from *state, to *state, input fsm.Input) {
+	am.enter(to)
+}
+
+func (am *WalkingAnimationManager) currentState() *state {
+	if am.override != nil {
+		return am.override.Current()
+	}
+	return am.base.Current()
+}
+
+func (am *WalkingAnimationManager) GetCurrentFrame() *ebiten.Image {
+	if am.layered() {
+		return am.compose()
+	}
+	if cur := am.currentState(); cur != nil {
+		return cur.Data.Image
 	}
 	return nil
 }
 
+// layered reports whether an override is playing on a body layer, over the base clip.
+func (am *WalkingAnimationManager) layered() bool {
+	return am.override != nil && am.override.Current().Data.Layer != nil
+}
+
+// compose draws the base frame, then each layered frame in its band, by priority.
+func (am *WalkingAnimationManager) compose() *ebiten.Image {
+	size := am.FrameSize
+	if am.composite == nil {
+		am.composite = ebiten.NewImage(size, size)
+	}
+	am.composite.Clear()
+
+	base := &model.AnimationLayer{Priority: 0, Top: 0, Bottom: size}
+	frames := []*animFrame{am.base.Current().Data, am.override.Current().Data}
+	layers := []*model.AnimationLayer{base, am.override.Current().Data.Layer}
+	if layers[1].Priority < layers[0].Priority {
+		frames[0], frames[1] = frames[1], frames[0]
+		layers[0], layers[1] = layers[1], layers[0]
+	}
+
+	for i, frame := range frames {
+		band := image.Rect(0, layers[i].Top, size, layers[i].Bottom)
+		am.composite.SubImage(band).(*ebiten.Image).Clear()
+		src := frame.Image.SubImage(band.Add(frame.Image.Bounds().Min)).(*ebiten.Image)
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Translate(0, float64(band.Min.Y))
+		am.composite.DrawImage(src, op)
+	}
+	return am.composite
+}
+
+// CurrentClip is the name of the clip being shown, e.g. "walk" or "block".
+func (am *WalkingAnimationManager) CurrentClip() string {
+	if cur := am.currentState(); cur != nil {
+		return cur.Data.Clip
+	}
+	return ""
+}
+
+// Machine returns the machine driving the shown frame: the override's while one plays, else the base clip's.
+func (am *WalkingAnimationManager) Machine() *fsm.Machine[*animFrame] {
+	if am.override != nil {
+		return am.override
+	}
+	return am.base
+}
+
+// advance reports whether the current frame has been shown for its full duration, consuming that time.
+func advance(cur *state, timeInState *time.Duration) bool {
+	d := cur.Data.Duration
+	if *timeInState < d {
+		return false
+	}
+	*timeInState -= d
+	if *timeInState >= d {
+		// fell far behind (e.g. paused), don't fast-forward through frames
+		*timeInState = 0
+	}
+	return true
+}
+
+// turnOverride moves a layered override to the same frame of its clip facing the base clip's new direction.
+func (am *WalkingAnimationManager) turnOverride() {
+	cur := am.override.Current().Data
+	s := am.base.Current().Target(fsm.Input(cur.Clip))
+	if s == nil {
+		return
+	}
+	for i := 0; i < cur.Index; i++ {
+		s = s.Next()
+	}
+	// a new machine, so the frame's events don't fire again
+	am.override = fsm.NewMachine(s)
+	am.override.OnTransition = am.onTransition
+}
+
+// Play shows a clip once (e.g. "spellcast" when firing, "hurt" when hit), over the base clip.
+// Clips that are held (block, strife) take over from it.
+func (am *WalkingAnimationManager) Play(clip string) {
+	if am.playing == clip && am.override != nil {
+		// already playing, restart it
+		am.override = nil
+	}
+	am.playing = clip
+	am.finishedOverride = ""
+}
+
 func (am *WalkingAnimationManager) UpdateByDirection(dirX, dirY float64, dt time.Duration, moving bool, overrideInput string) {
-	var nextState *state
-	am.timeInState += dt
+	if overrideInput == "" {
+		overrideInput = am.playing
+	} else {
+		am.playing = ""
+	}
 
 	if len(overrideInput) > 0 {
+		if am.override != nil && am.CurrentClip() != overrideInput {
+			// a different clip was asked for, switch to it
+			am.override = nil
+			am.finishedOverride = ""
+		}
 		// Init override if not already set
-		if am.overrideState == nil {
-			override := am.curState.SendInput(overrideInput)
+		if am.override == nil && overrideInput != am.finishedOverride {
+			override := am.base.Current().Target(fsm.Input(overrideInput))
 			if override != nil {
-				am.overrideState = override
+				am.override = fsm.NewMachine(override)
+				am.override.OnTransition = am.onTransition
+				am.overrideTime = 0
+				am.enter(override)
 			}
 		}
 	} else {
-		am.overrideState = nil
-	}
-
-	if am.overrideState != nil {
-		am.overrideState = am.overrideState.Next()
-		return
+		if am.override != nil && !am.layered() {
+			am.timeInState = 0
+		}
+		am.override = nil
+		am.finishedOverride = ""
 	}
 
-	if am.timeInState < 150*time.Millisecond && len(overrideInput) == 0 {
-		return
+	if am.override != nil {
+		layered := am.layered()
+		am.overrideTime += dt
+		if advance(am.override.Current(), &am.overrideTime) {
+			if am.override.Current().Data.Once {
+				// play-once clip is done, back to the base clip
+				am.override = nil
+				if am.playing == overrideInput {
+					am.playing = ""
+				} else {
+					am.finishedOverride = overrideInput
+				}
+				if !layered {
+					am.timeInState = 0
+				}
+				return
+			}
+			am.override.Send(fsm.Next)
+		}
+		if !layered {
+			// the whole frame is the override's, the base clip waits
+			return
+		}
 	}
 
-	am.timeInState = 0
+	am.timeInState += dt
 
-	dirInput := ""
-	// find direction it is most in
-	if math.Abs(dirX) > math.Abs(dirY/2) {
-		if dirX > 0 {
-			dirInput = "right"
-		} else {
-			dirInput = "left"
+	// stand still in the idle clip, if there is one
+	if am.idleClip != "" {
+		want := am.baseClip
+		if !moving {
+			want = am.idleClip
 		}
-	} else {
-		if dirY > 0 {
-			dirInput = "down"
-		} else {
-			dirInput = "up"
+		if am.base.Current().Data.Clip != want && am.base.Send(fsm.Input(want)) {
+			am.timeInState = 0
+			if want == am.baseClip {
+				// idle may have stood facing the nearest way it has, walk the way it was facing
+				am.base.Send(fsm.Input(am.facing))
+			}
+			return
 		}
 	}
 
-	nextState = am.curState.SendInput(dirInput)
-	if nextState == nil {
-		// nil means within same dfa
-		if !moving {
+	if !advance(am.base.Current(), &am.timeInState) {
+		return
+	}
+
+	// turn to face the aim, before stepping to the next frame
+	if facing := facingFor(dirX, dirY, am.facing, am.facings, am.FacingHysteresis); facing != am.facing {
+		if am.base.Send(fsm.Input(facing)) {
+			am.facing = facing
+			if am.layered() {
+				am.turnOverride()
+			}
 			return
 		}
-		nextState = am.curState.Next()
+		if am.base.Current().Data.Clip == am.idleClip {
+			// idle has no clip facing this way, and already stands facing the nearest one
+			am.facing = facing
+		}
 	}
-
-	am.curState = nextState
+	if !moving && am.idleClip == "" {
+		return
+	}
+	am.base.Send(fsm.Next)
 }
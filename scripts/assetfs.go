@@ -0,0 +1,29 @@
+/*
+This file contains the file system every asset is read from. Paths are slash separated and relative
+to the repo root (e.g. "assets/enemies/skeletonspritesheet.png"), whichever fs.FS is behind them.
+*/
+package scripts
+
+import (
+	"io/fs"
+	"os"
+)
+
+// assetFS defaults to the working directory so `go run .` from the repo root works during development.
+// Release builds swap in an embed.FS (see assets_embed.go in the main package).
+var assetFS fs.FS = os.DirFS(".")
+
+// assetDir is the directory assetFS reads from, or "" when it isn't backed by the OS file system.
+var assetDir = "."
+
+// SetAssetFS serves assets from fsys, e.g. an embed.FS for single-binary releases.
+func SetAssetFS(fsys fs.FS) {
+	assetFS = fsys
+	assetDir = ""
+}
+
+// SetAssetDir serves assets from a directory on disk, overriding any embedded assets.
+func SetAssetDir(dir string) {
+	assetFS = os.DirFS(dir)
+	assetDir = dir
+}
@@ -0,0 +1,231 @@
+/*
+This file contains the AssetManager, which decodes each image file once and hands out cached
+sub-images (animation frames, tiles) so sprite sheets aren't re-read for every frame or entity.
+*/
+package scripts
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/fs"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+type subImageKey struct {
+	path string
+	rect image.Rectangle
+}
+
+type AssetManager struct {
+	images    map[string]*ebiten.Image
+	subImages map[subImageKey]*ebiten.Image
+
+	// Hot reload (dev mode): every loaded image is watched and redrawn in place when it changes
+	watcher  *FileWatcher
+	OnReload func(path string, err error)
+}
+
+// AssetError describes why an asset couldn't be loaded, with a hint on how to fix it.
+type AssetError struct {
+	Path string
+	Err  error
+	Hint string
+}
+
+func (e *AssetError) Error() string {
+	msg := fmt.Sprintf("asset %q: %v", e.Path, e.Err)
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+func (e *AssetError) Unwrap() error { return e.Err }
+
+func NewAssetManager() *AssetManager {
+	return &AssetManager{
+		images:    make(map[string]*ebiten.Image),
+		subImages: make(map[subImageKey]*ebiten.Image),
+	}
+}
+
+// Image returns the decoded image at path, loading it on first use.
+func (am *AssetManager) Image(path string) (*ebiten.Image, error) {
+	if img, ok := am.images[path]; ok {
+		return img, nil
+	}
+
+	decoded, err := decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	img := ebiten.NewImageFromImage(decoded)
+	am.images[path] = img
+	if am.watcher != nil {
+		am.watcher.Watch(path, am.reload)
+	}
+	return img, nil
+}
+
+// Set stores a generated image (e.g. a composited character sheet) as the image at path.
+// If path is already loaded at the same size, img is drawn over its pixels instead, so sub-images
+// cut from it update. Generated images aren't hot reloaded.
+func (am *AssetManager) Set(path string, img *ebiten.Image) {
+	if am.watcher != nil {
+		am.watcher.Unwatch(path)
+	}
+	if old, ok := am.images[path]; ok {
+		if old.Bounds().Size() == img.Bounds().Size() {
+			old.Clear()
+			old.DrawImage(img, nil)
+			img.Deallocate()
+			return
+		}
+		am.Unload(path)
+	}
+	am.images[path] = img
+}
+
+func decodeImage(path string) (image.Image, error) {
+	f, err := assetFS.Open(path)
+	if err != nil {
+		return nil, &AssetError{Path: path, Err: err, Hint: missingHint(path, err)}
+	}
+	defer f.Close()
+
+	decoded, _, err := image.Decode(f)
+	if err != nil {
+		return nil, &AssetError{Path: path, Err: err, Hint: "not a PNG image?"}
+	}
+	return decoded, nil
+}
+
+// EnableHotReload watches every image loaded so far (and from now on) with fw.
+func (am *AssetManager) EnableHotReload(fw *FileWatcher) {
+	am.watcher = fw
+	for path := range am.images {
+		fw.Watch(path, am.reload)
+	}
+}
+
+// Reload re-reads the image at path and overwrites the pixels of the cached image.
+// Sub-images share those pixels, so every animation frame and tile cut from it updates too.
+func (am *AssetManager) Reload(path string) error {
+	img, ok := am.images[path]
+	if !ok {
+		return nil
+	}
+
+	decoded, err := decodeImage(path)
+	if err != nil {
+		return err
+	}
+	if decoded.Bounds().Size() != img.Bounds().Size() {
+		return &AssetError{
+			Path: path,
+			Err:  fmt.Errorf("size changed from %v to %v", img.Bounds().Size(), decoded.Bounds().Size()),
+			Hint: "frames are cut by size, restart to pick up a resized sheet",
+		}
+	}
+
+	rgba := image.NewRGBA(decoded.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+	img.WritePixels(rgba.Pix)
+	return nil
+}
+
+func (am *AssetManager) reload(path string) {
+	err := am.Reload(path)
+	if am.OnReload != nil {
+		am.OnReload(path, err)
+	}
+}
+
+// SubImage returns the rect region of the image at path. The same (path, rect) always returns the same image.
+func (am *AssetManager) SubImage(path string, rect image.Rectangle) (*ebiten.Image, error) {
+	key := subImageKey{path: path, rect: rect}
+	if img, ok := am.subImages[key]; ok {
+		return img, nil
+	}
+
+	sheet, err := am.Image(path)
+	if err != nil {
+		return nil, err
+	}
+	if !rect.In(sheet.Bounds()) {
+		return nil, &AssetError{
+			Path: path,
+			Err:  fmt.Errorf("frame %v is outside the %dx%d image", rect, sheet.Bounds().Dx(), sheet.Bounds().Dy()),
+			Hint: "check the row/column and frame size",
+		}
+	}
+
+	img := sheet.SubImage(rect).(*ebiten.Image)
+	am.subImages[key] = img
+	return img, nil
+}
+
+// Unload drops the image at path and every sub-image cut from it.
+// Anything still holding those images must not draw them afterwards.
+func (am *AssetManager) Unload(path string) {
+	img, ok := am.images[path]
+	if !ok {
+		return
+	}
+	for key := range am.subImages {
+		if key.path == path {
+			delete(am.subImages, key)
+		}
+	}
+	delete(am.images, path)
+	if am.watcher != nil {
+		am.watcher.Unwatch(path)
+	}
+	img.Deallocate()
+}
+
+func (am *AssetManager) UnloadAll() {
+	for path := range am.images {
+		am.Unload(path)
+	}
+}
+
+// missingHint suggests what went wrong when a file can't be opened.
+func missingHint(path string, err error) string {
+	if !errors.Is(err, fs.ErrNotExist) {
+		return ""
+	}
+
+	dir := pathpkg.Dir(path)
+	entries, dirErr := fs.ReadDir(assetFS, dir)
+	if dirErr != nil {
+		if assetDir == "" {
+			return fmt.Sprintf("directory %q isn't in the embedded assets", dir)
+		}
+		root, _ := filepath.Abs(assetDir)
+		return fmt.Sprintf("directory %q doesn't exist either, assets are loaded relative to %q; run the game from the repo root or pass -assets", dir, root)
+	}
+
+	// suggest files with a similar name, e.g. a typo or wrong extension
+	base := strings.ToLower(strings.TrimSuffix(pathpkg.Base(path), pathpkg.Ext(path)))
+	var similar []string
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if strings.Contains(name, base) || strings.Contains(base, strings.TrimSuffix(name, pathpkg.Ext(name))) {
+			similar = append(similar, entry.Name())
+		}
+	}
+	if len(similar) == 0 {
+		return fmt.Sprintf("no similar files in %q", dir)
+	}
+	sort.Strings(similar)
+	return fmt.Sprintf("did you mean %s in %q?", strings.Join(similar, ", "), dir)
+}
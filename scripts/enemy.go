@@ -1,17 +1,49 @@
 package scripts
 
 import (
+	"fmt"
+	"game/fsm"
+	"game/model"
+	"image"
+	"math"
 	"math/rand"
 	"time"
 )
 
 var AllEnemies []*Enemy
 
+// once more enemies than this are alive they all follow the map's flow field, rather than each planning a path
+const flowFieldHorde = 40
+
+// set each Update, see flowFieldHorde
+var hordeMode bool
+
 type Collider struct {
 	radius         float32
 	offsetPosition *Vec2
 }
 
+// SteeringWeights tune how an archetype moves in a crowd, see Enemy.crowdSteer. Weights scale the
+// behaviours of model/steering.go before they're added up.
+type SteeringWeights struct {
+	Seek             float32 // toward the player, along the path or flow field
+	Separation       float32 // away from enemies closer than SeparationRadius
+	Cohesion         float32 // toward the middle of enemies within NeighbourRadius
+	SeparationRadius float32 // px
+	NeighbourRadius  float32 // px
+	ArriveRadius     float32 // px from its spot around the player where it starts slowing down
+}
+
+// skeletons keep about half their width apart, and barely flock
+var skeletonSteering = SteeringWeights{
+	Seek:             1,
+	Separation:       1.5,
+	Cohesion:         0.2,
+	SeparationRadius: 30,
+	NeighbourRadius:  96,
+	ArriveRadius:     24,
+}
+
 type Enemy struct {
 	Pos             *Vec2
 	Direction       *Vec2
@@ -25,12 +57,240 @@ type Enemy struct {
 	OriginalPos     *Vec2
 	Name            string
 	AggroRadius     float32
-	RandomOffset    *Vec2
+	RandomOffset    *Vec2 // unit vector, where around the player it heads for
+	Steering        SteeringWeights
 	Width           float32
 	Colliders       []Collider
+	AI              *fsm.Machine[*Enemy]
+	Damage          int     // hearts taken from the player per attack
+	AttackCooldown  float32 // seconds between attacks
+	attackTimer     float32
+	Velocity        *Vec2   // px/s, from knockback, on top of walking
+	Mass            float32 // divides knockback impulses, heavier enemies are pushed less
+	Friction        float32 // per second, how fast Velocity dies down
+	StaggerSec      float32 // seconds a knockback hit stops it in its tracks
+	staggerTimer    float32
+	detourSide      float64 // 1 or -1, the way it last turned around an obstacle, kept so it doesn't dither
+
+	// the path to the player, replanned every pathReplanSec or so
+	path      []Vec2
+	pathGoal  image.Point // the walk grid cell path leads to
+	pathTimer float32
+
+	touching map[*Projectile]bool // projectiles overlapping it last tick, each knocks back once
+
+	// set each Update for the AI states
+	target *Player
+	dt     float32
+}
+
+// sent to the AI when a projectile knocks the enemy back
+const enemyHit fsm.Input = "hit"
+
+// newEnemyAI builds the idle -> chase -> attack machine. Transitions are Auto, taken as soon as their guard
+// passes, except enemyHit which staggers it from any of them until staggerTimer runs out.
+func newEnemyAI(e *Enemy) *fsm.Machine[*Enemy] {
+	idle := fsm.NewState("idle", e)
+	chase := fsm.NewState("chase", e)
+	attack := fsm.NewState("attack", e)
+	stagger := fsm.NewState("stagger", e)
+
+	for _, s := range []*fsm.State[*Enemy]{idle, chase, attack} {
+		s.AddTransition(enemyHit, stagger)
+	}
+	stagger.AddGuardedTransition(fsm.Auto, idle, func() bool { return e.staggerTimer <= 0 })
+
+	idle.AddGuardedTransition(fsm.Auto, chase, e.playerInAggro)
+	chase.AddGuardedTransition(fsm.Auto, attack, e.playerInReach)
+	chase.AddGuardedTransition(fsm.Auto, idle, func() bool { return !e.playerInAggro() })
+	attack.AddGuardedTransition(fsm.Auto, chase, e.playerInAggro)
+	attack.AddGuardedTransition(fsm.Auto, idle, func() bool { return !e.playerInAggro() && !e.playerInReach() })
+
+	idle.OnUpdate = func(dt float32) { e.standStill() }
+	chase.OnUpdate = func(dt float32) { e.chase() }
+	attack.OnEnter = func(from *fsm.State[*Enemy], input fsm.Input) { e.attackTimer = e.AttackCooldown / 2 }
+	attack.OnUpdate = func(dt float32) {
+		e.attack(dt)
+		e.keepApart()
+	}
+	stagger.OnUpdate = func(dt float32) { e.standStill() }
+
+	return fsm.NewMachine(idle)
+}
+
+func (e *Enemy) playerInReach() bool {
+	return e.Pos.Distance(e.target.Pos) <= e.target.Width/2
 }
 
-func NewSkeletonEnemy(pos *Vec2) *Enemy {
+// playerInAggro is true when the player is close enough to chase, but not yet in reach
+func (e *Enemy) playerInAggro() bool {
+	d := e.Pos.Distance(e.target.Pos)
+	return d <= e.AggroRadius && d > e.target.Width/2
+}
+
+// standStill plays the idle clip, facing the player
+func (e *Enemy) standStill() {
+	toPlayer := e.target.Pos.Sub(e.Pos)
+	e.WalkAnimator.UpdateByDirection(float64(toPlayer.X), float64(toPlayer.Y), time.Duration(e.dt*1000)*time.Millisecond, false, "")
+}
+
+func (e *Enemy) attack(dt float32) {
+	e.attackTimer += dt
+	if e.attackTimer >= e.AttackCooldown {
+		e.attackTimer = 0
+		// the blow lands on the slash's hit frame, see NewSkeletonEnemy
+		e.WalkAnimator.Play("slash")
+	}
+	e.standStill()
+}
+
+func (e *Enemy) chase() {
+	player := e.target
+	dtMs := time.Duration(e.dt*1000) * time.Millisecond
+	var targetDest = player.Pos.Add(e.RandomOffset.Mul(player.Width / 4))
+	var toDest *Vec2
+	if hordeMode {
+		toDest = e.followFlow(targetDest)
+	} else {
+		toDest = e.followPath(targetDest)
+	}
+	desired := e.crowdSteer(toDest.Norm(), targetDest)
+	// avoid obstacles, at the speed the crowd allows
+	moveDirection := e.steer(desired.Norm()).Mul(desired.Length())
+
+	e.move(moveDirection.Mul(e.Speed * e.dt))
+	e.WalkAnimator.UpdateByDirection(float64(moveDirection.X), float64(moveDirection.Y), dtMs, moveDirection.Length() > 0, "")
+}
+
+// crowdSteer weighs seeking along navDir, arriving at dest, and keeping apart from and together with
+// nearby enemies into the velocity it wants, at most unit length.
+func (e *Enemy) crowdSteer(navDir *Vec2, dest *Vec2) *Vec2 {
+	w := e.Steering
+	seek := navDir
+	if e.Pos.Distance(dest) < w.ArriveRadius {
+		seek = model.Arrive(*e.Pos, *dest, w.ArriveRadius)
+	}
+	neighbours := enemyGrid.Neighbours(e, max(w.NeighbourRadius, w.SeparationRadius))
+	desired := seek.Mul(w.Seek).
+		Add(model.Separation(*e.Pos, neighbours, w.SeparationRadius, e.RandomOffset).Mul(w.Separation)).
+		Add(model.Cohesion(*e.Pos, neighbours, w.NeighbourRadius).Mul(w.Cohesion))
+	if desired.Length() > 1 {
+		return desired.Norm()
+	}
+	return desired
+}
+
+// keepApart sidesteps enemies crowding it while it stands and attacks.
+func (e *Enemy) keepApart() {
+	push := model.Separation(*e.Pos, enemyGrid.Neighbours(e, e.Steering.SeparationRadius), e.Steering.SeparationRadius, e.RandomOffset)
+	e.move(push.Mul(e.Steering.Separation * e.Speed * e.dt / 2))
+}
+
+// seconds between path replans, each enemy's interval is jittered so they don't all plan on the same frame
+const pathReplanSec = 0.5
+
+// followPath returns the direction to walk toward dest, along the path planned around obstacles. The path
+// is kept until the replan timer runs out, and even then while dest stays in the same cell and the next
+// waypoint is still in a straight line.
+func (e *Enemy) followPath(dest *Vec2) *Vec2 {
+	grid := tileMap.Walk
+	// floor, not truncate, so an enemy knocked past the top or left edge isn't in cell 0
+	cellOf := func(v *Vec2) image.Point {
+		return grid.CellAt(image.Point{X: int(math.Floor(float64(v.X))), Y: int(math.Floor(float64(v.Y)))})
+	}
+
+	e.pathTimer -= e.dt
+	goal := cellOf(dest)
+	// off the path, e.g. knocked back behind an obstacle, when the next waypoint isn't in a straight line
+	lost := len(e.path) > 0 && !grid.Clear(cellOf(e.Pos), cellOf(&e.path[0]))
+	if e.pathTimer <= 0 && (goal != e.pathGoal || len(e.path) == 0 || lost) {
+		e.pathTimer = pathReplanSec * (0.75 + rand.Float32()*0.5)
+		e.pathGoal = goal
+		e.path = e.path[:0]
+		cells := grid.Smooth(grid.FindPath(cellOf(e.Pos), goal))
+		// the first cell is where it stands
+		for i := 1; i < len(cells); i++ {
+			c := grid.Center(cells[i])
+			e.path = append(e.path, Vec2{X: float32(c.X), Y: float32(c.Y)})
+		}
+	}
+
+	for len(e.path) > 0 && e.Pos.Distance(&e.path[0]) < float32(grid.CellSize)/2 {
+		e.path = e.path[1:]
+	}
+	// the last leg, or no path: straight at dest
+	if len(e.path) <= 1 {
+		return dest.Sub(e.Pos)
+	}
+	return e.path[0].Sub(e.Pos)
+}
+
+// followFlow returns the direction to walk toward dest down the flow field, which leads to the player.
+// Near the player, or off the field, it walks straight at dest.
+func (e *Enemy) followFlow(dest *Vec2) *Vec2 {
+	dir := tileMap.Flow.Direction(*e.Pos)
+	if dir.Length() == 0 || e.Pos.Distance(dest) < float32(tileMap.Walk.CellSize)*2 {
+		return dest.Sub(e.Pos)
+	}
+	return dir
+}
+
+func aliveEnemies() int {
+	n := 0
+	for _, e := range AllEnemies {
+		if !e.IsDead() {
+			n++
+		}
+	}
+	return n
+}
+
+// detour angles tried in order when the way ahead is blocked, turning to detourSide first
+var detourAngles = []float64{0, math.Pi / 4, -math.Pi / 4, math.Pi / 2, -math.Pi / 2, math.Pi * 3 / 4, -math.Pi * 3 / 4}
+
+// steer returns dir, or the closest direction to it that isn't blocked a step ahead, so the enemy
+// walks around obstacles rather than into them.
+func (e *Enemy) steer(dir *Vec2) *Vec2 {
+	if e.detourSide == 0 {
+		e.detourSide = 1
+	}
+	lookAhead := e.Width / 2
+	for _, angle := range detourAngles {
+		d := dir.Rotate(angle * e.detourSide)
+		if !tileMap.Blocks(*e.Pos.Add(d.Mul(lookAhead)), e.footprint()) {
+			if angle < 0 {
+				e.detourSide = -e.detourSide
+			}
+			return d
+		}
+	}
+	return dir
+}
+
+// footprint is the half size of the square the enemy stands on
+func (e *Enemy) footprint() float32 {
+	return e.Width / 4
+}
+
+// move moves by vel, sliding along obstacles. An enemy already inside one, e.g. knocked into it, may move out.
+func (e *Enemy) move(vel *Vec2) {
+	free := func(pos *Vec2) bool {
+		return !tileMap.Blocks(*pos, e.footprint()) || tileMap.Blocks(*e.Pos, e.footprint())
+	}
+	if free(e.Pos.Add(vel)) {
+		e.Pos = e.Pos.Add(vel)
+	} else if free(e.Pos.Add(&Vec2{X: vel.X})) {
+		e.Pos = e.Pos.Add(&Vec2{X: vel.X})
+	} else if free(e.Pos.Add(&Vec2{Y: vel.Y})) {
+		e.Pos = e.Pos.Add(&Vec2{Y: vel.Y})
+	}
+}
+
+func NewSkeletonEnemy(pos *Vec2) (*Enemy, error) {
+	walkAnimator, err := NewCharacterWalkingAnimator(skeletonManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("skeleton: %w", err)
+	}
 
 	newEnemy := &Enemy{
 		Pos:             pos,
@@ -42,12 +302,20 @@ func NewSkeletonEnemy(pos *Vec2) *Enemy {
 		Health:          100,
 		RespawnCooldown: 5,
 		RespawnTimer:    0,
-		WalkAnimator:    NewCharacterWalkingAnimator("assets/enemies/skeletonspritesheet.png"),
+		WalkAnimator:    walkAnimator,
+		Velocity:        &Vec2{},
+		Mass:            1,
+		Friction:        8,
+		StaggerSec:      0.25,
+		touching:        make(map[*Projectile]bool),
 		Name:            "Skeleton",
 		AggroRadius:     500,
 		// so all enemies don't flock to same place
-		RandomOffset: &(Vec2{X: float32(rand.Intn(2)) - 1, Y: float32(rand.Intn(2)) - 1}),
-		Width:        64,
+		RandomOffset:   (&Vec2{X: 1}).Rotate(rand.Float64() * 2 * math.Pi),
+		Steering:       skeletonSteering,
+		Width:          64,
+		Damage:         1,
+		AttackCooldown: 1,
 	}
 
 	// have a few colliders, 3 top, 3 middle, 3 bottom
@@ -76,8 +344,15 @@ func NewSkeletonEnemy(pos *Vec2) *Enemy {
 	colliders = append(colliders, Collider{radius: colliderRadius, offsetPosition: &Vec2{X: leftColliderX + colliderGapX*2, Y: topColliderY + colliderGapY*2}})
 
 	newEnemy.Colliders = colliders
+	newEnemy.AI = newEnemyAI(newEnemy)
+	walkAnimator.Subscribe("hit", func(AnimationEvent) {
+		// missed if the player got away during the swing
+		if newEnemy.target != nil && newEnemy.playerInReach() {
+			newEnemy.target.Hurt(newEnemy.Damage)
+		}
+	})
 	AllEnemies = append(AllEnemies, newEnemy)
-	return newEnemy
+	return newEnemy, nil
 }
 
 func (e *Enemy) IsDead() bool {
@@ -85,8 +360,6 @@ func (e *Enemy) IsDead() bool {
 }
 
 func (e *Enemy) Update(dt float32, player *Player) {
-	dtMs := time.Duration(dt*1000) * time.Millisecond
-
 	surroundingProjectiles := player.ProjectileGrid.GetSurroundingProjectiles(e.Pos, int(e.Width)*2)
 	// hack, get raw list of projectiles from player weapons
 	// var surroundingProjectiles []*Projectile
@@ -94,37 +367,54 @@ func (e *Enemy) Update(dt float32, player *Player) {
 	// 	surroundingProjectiles = append(surroundingProjectiles, weapon.Projectiles...)
 	// }
 
-	knockbackVector := &Vec2{X: 0, Y: 0}
+	wasDead := e.IsDead()
+	wasTouching := e.touching
+	e.touching = make(map[*Projectile]bool)
+	hit := false
 	for _, proj := range surroundingProjectiles {
 		// check if close to any collider within its radius
 		for _, collider := range e.Colliders {
 			if proj.Pos.Distance(e.Pos.Add(collider.offsetPosition)) <= collider.radius {
 				// Handle collision
-				knockbackVector = knockbackVector.Add(proj.Dir)
+				e.touching[proj] = true
+				if !wasTouching[proj] && proj.Knockback > 0 {
+					e.Velocity = e.Velocity.Add(proj.Dir.Mul(proj.Knockback / e.Mass))
+					hit = true
+				}
 				e.Health -= 1
+				particleManager.Spawn("hit_spark", proj.Pos, proj.Dir)
+				if e.WalkAnimator.CurrentClip() != "hurt" {
+					e.WalkAnimator.Play("hurt")
+				}
 				break
 			}
 		}
 	}
 
 	if e.IsDead() {
+		if !wasDead {
+			particleManager.Spawn("death_poof", e.Pos, nil)
+		}
 		return
 	}
 
-	if e.Pos.Distance(player.Pos) <= e.AggroRadius && e.Pos.Distance(player.Pos) > player.Width/2 {
-		var targetDest = player.Pos.Add(e.RandomOffset.Mul(player.Width / 4))
-		var moveDirection *Vec2 = &Vec2{
-			X: float32(targetDest.X - e.Pos.X),
-			Y: float32(targetDest.Y - e.Pos.Y),
-		}
-		moveDirection = moveDirection.Norm()
+	e.target = player
+	e.dt = dt
+	e.applyVelocity(dt)
+	if hit {
+		e.staggerTimer = e.StaggerSec
+		e.AI.Send(enemyHit)
+	}
+	e.staggerTimer -= dt
+	e.AI.Update(dt)
+}
 
-		vel := moveDirection.Mul(e.Speed * dt).Add(knockbackVector)
-		e.Pos = e.Pos.Add(vel)
-		e.WalkAnimator.UpdateByDirection(float64(moveDirection.X), float64(moveDirection.Y), dtMs, true, "")
-	} else if e.Pos.Distance(player.Pos) <= player.Width/2 {
-		// stop moving
-		//e.WalkAnimator.UpdateByDirection(0, 0, dtMs, false, "")
-		// Attack Animation: TODO!
+// applyVelocity moves by the knockback velocity, sliding along obstacles, and lets friction slow it.
+func (e *Enemy) applyVelocity(dt float32) {
+	if e.Velocity.Length() < 1 {
+		e.Velocity = &Vec2{}
+		return
 	}
+	e.move(e.Velocity.Mul(dt))
+	e.Velocity = e.Velocity.Mul(float32(math.Exp(float64(-e.Friction * dt))))
 }
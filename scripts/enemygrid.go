@@ -0,0 +1,60 @@
+/*
+This file contains the EnemyGrid, the living enemies bucketed by position each tick so crowd steering only
+looks at the enemies nearby.
+*/
+package scripts
+
+import (
+	"image"
+	"math"
+)
+
+type EnemyGrid struct {
+	CellSize int
+	Cells    map[image.Point][]*Enemy
+}
+
+// enemyGrid is rebuilt at the start of every tick, see Game.Update.
+var enemyGrid = NewEnemyGrid(64)
+
+func NewEnemyGrid(cellSize int) *EnemyGrid {
+	return &EnemyGrid{
+		CellSize: cellSize,
+		Cells:    make(map[image.Point][]*Enemy),
+	}
+}
+
+func (eg *EnemyGrid) cellAt(pos *Vec2) image.Point {
+	// floor, not truncate, like ObstacleGrid, so enemies knocked past the top or left edge don't share cell 0
+	size := float64(eg.CellSize)
+	return image.Point{X: int(math.Floor(float64(pos.X) / size)), Y: int(math.Floor(float64(pos.Y) / size))}
+}
+
+// Rebuild buckets the living enemies.
+func (eg *EnemyGrid) Rebuild(enemies []*Enemy) {
+	clear(eg.Cells)
+	for _, e := range enemies {
+		if e.IsDead() {
+			continue
+		}
+		cell := eg.cellAt(e.Pos)
+		eg.Cells[cell] = append(eg.Cells[cell], e)
+	}
+}
+
+// Neighbours returns the positions of the other living enemies within radius of e.
+func (eg *EnemyGrid) Neighbours(e *Enemy, radius float32) []Vec2 {
+	var near []Vec2
+	min := eg.cellAt(&Vec2{X: e.Pos.X - radius, Y: e.Pos.Y - radius})
+	max := eg.cellAt(&Vec2{X: e.Pos.X + radius, Y: e.Pos.Y + radius})
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			for _, other := range eg.Cells[image.Point{X: x, Y: y}] {
+				if other != e && e.Pos.Distance(other.Pos) < radius {
+					near = append(near, *other.Pos)
+				}
+			}
+		}
+	}
+	return near
+}
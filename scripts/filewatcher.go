@@ -0,0 +1,110 @@
+/*
+This file contains the FileWatcher, which polls asset files and calls back when they change.
+Embedded assets never change (their mod time is always zero), so watching is a no-op in release builds.
+Polling (instead of OS notifications) keeps it dependency free and works the same on every platform.
+*/
+package scripts
+
+import (
+	"io/fs"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+type watchedFile struct {
+	modTime  time.Time
+	onChange func(path string)
+}
+
+type watchedDir struct {
+	ext      string
+	onChange func(path string)
+}
+
+type FileWatcher struct {
+	FS        fs.FS
+	Interval  float32 // seconds between polls
+	sincePoll float32
+	files     map[string]*watchedFile
+	dirs      map[string]*watchedDir
+}
+
+func NewFileWatcher(fsys fs.FS, interval float32) *FileWatcher {
+	return &FileWatcher{
+		FS:       fsys,
+		Interval: interval,
+		files:    make(map[string]*watchedFile),
+		dirs:     make(map[string]*watchedDir),
+	}
+}
+
+// Watch calls onChange whenever path's modification time changes.
+func (fw *FileWatcher) Watch(path string, onChange func(path string)) {
+	fw.files[path] = &watchedFile{
+		modTime:  modTime(fw.FS, path),
+		onChange: onChange,
+	}
+}
+
+func (fw *FileWatcher) Unwatch(path string) {
+	delete(fw.files, path)
+}
+
+// WatchDir watches every file in dir ending in ext, including ones created later.
+func (fw *FileWatcher) WatchDir(dir string, ext string, onChange func(path string)) {
+	fw.dirs[dir] = &watchedDir{ext: ext, onChange: onChange}
+	for _, path := range listDir(fw.FS, dir, ext) {
+		fw.Watch(path, onChange)
+	}
+}
+
+// Poll checks for changes once every Interval seconds.
+func (fw *FileWatcher) Poll(dt float32) {
+	fw.sincePoll += dt
+	if fw.sincePoll < fw.Interval {
+		return
+	}
+	fw.sincePoll = 0
+
+	// pick up new files
+	for dir, wd := range fw.dirs {
+		for _, path := range listDir(fw.FS, dir, wd.ext) {
+			if _, ok := fw.files[path]; !ok {
+				fw.files[path] = &watchedFile{onChange: wd.onChange}
+			}
+		}
+	}
+
+	for path, wf := range fw.files {
+		mt := modTime(fw.FS, path)
+		if mt.IsZero() || mt.Equal(wf.modTime) {
+			// missing (maybe mid-save) or unchanged
+			continue
+		}
+		wf.modTime = mt
+		wf.onChange(path)
+	}
+}
+
+func modTime(fsys fs.FS, path string) time.Time {
+	info, err := fs.Stat(fsys, path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func listDir(fsys fs.FS, dir string, ext string) []string {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ext) {
+			paths = append(paths, pathpkg.Join(dir, entry.Name()))
+		}
+	}
+	return paths
+}
@@ -3,17 +3,16 @@ package scripts
 import (
 	"fmt"
 	"game/model"
-	"image"
 	"image/color"
 	"log"
 	"math/rand"
-	"os"
 	"time"
 
 	_ "image/png" // PNG decoder
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 
 	// shaders/retro_shader
 
@@ -29,67 +28,41 @@ const (
 //go:embed shaders/retro.kage
 var retroShaderSrc []byte
 
-var earthImage *ebiten.Image
-var smokeImage *ebiten.Image
-var fireImage *ebiten.Image
+var assetManager = NewAssetManager()
 var heroAnimationManager *WalkingAnimationManager
 var statusBarAnimationManager *StatusBarAnimationManager
-var tilesPath = "assets/tiles"
+var particleManager *ParticleManager
+var fileWatcher *FileWatcher
 
-const tileW = 32
+var skeletonManifestPath = model.SkeletonManifest
+var heroManifestPath = model.HeroManifest
+var heroCharacterDir = "" // LPC character composited for the hero, see SetHero
+var skeletonCount = 5
 
-var allTiles []*ebiten.Image
-var tileLayer [][]*ebiten.Image
-
-// tiles match FieldsTile_x.png, where x is from 1-64
-
-var skeletonImagePath = "assets/enemies/skeletonspritesheet.png"
-var heroImagePath = "assets/characters/default.png"
-
-func loadImage(path string) *ebiten.Image {
-	f, err := os.Open(path)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	img, _, err := image.Decode(f)
-	if err != nil {
-		log.Fatal(err)
-	}
+// SetSkeletonCount spawns n skeletons rather than 5. Past flowFieldHorde they follow the flow field.
+func SetSkeletonCount(n int) {
+	skeletonCount = n
+}
 
-	return ebiten.NewImageFromImage(img)
+// SetHero plays as the character in assets/characters/<name>/, animated by its <name>.anim.json.
+func SetHero(name string) {
+	heroCharacterDir, heroManifestPath = model.HeroPaths(name)
 }
 
-func initAllTiles() {
-	for i := 1; i <= 64; i++ {
-		// If single digit, prefix with 0
-		path := fmt.Sprintf("%s/FieldsTile_%02d.png", tilesPath, i)
-		img := loadImage(path)
-		// convert it to 32x32
-		img = img.SubImage(image.Rect(0, 0, tileW, tileW)).(*ebiten.Image)
-		allTiles = append(allTiles, img)
-	}
+func loadImage(path string) (*ebiten.Image, error) {
+	return assetManager.Image(path)
 }
 
-func Init() {
-	const earthImagePath = "assets/earth.png"
-	const smokeImagePath = "assets/smoke.png"
-	const fireImagePath = "assets/fire.png"
-
-	earthImage = loadImage(earthImagePath)
-	smokeImage = loadImage(smokeImagePath)
-	fireImage = loadImage(fireImagePath)
-
-	initAllTiles()
-	// Create the map
-	tileLayer = make([][]*ebiten.Image, logicalH/tileW)
-	for i := range tileLayer {
-		tileLayer[i] = make([]*ebiten.Image, logicalW/tileW)
-		for j := range tileLayer[i] {
-			tileLayer[i][j] = allTiles[rand.Intn(len(allTiles))]
-		}
+func Init() error {
+	LoadParticleEffects(effectsPath)
+
+	// Load the map
+	m, err := LoadTileMap(mapPath)
+	if err != nil {
+		return err
 	}
+	tileMap = m
+	return nil
 }
 
 // -------------------- Game types --------------------
@@ -105,6 +78,13 @@ type Game struct {
 	offscreen    *ebiten.Image
 	startedAt    time.Time
 	off          *ebiten.Image
+
+	// dev mode
+	shaderWatcher *FileWatcher
+	shaderErr     error
+	devStatus     string
+
+	stateDebugger StateDebugger
 }
 
 // -------------------- Game loop --------------------
@@ -115,6 +95,21 @@ var GameInstance *Game
 
 func (g *Game) Update() error {
 	dt := float32(1.0 / TargetTPS)
+	if inpututil.IsKeyJustPressed(ebiten.KeyF2) {
+		particleManager.CycleQuality()
+	}
+	g.stateDebugger.Update(g)
+	// no scrolling camera yet, the player is the focus
+	fileWatcher.Poll(dt)
+	if g.shaderWatcher != nil {
+		g.shaderWatcher.Poll(dt)
+	}
+	particleManager.Update(dt, g.Player.Pos)
+	enemyGrid.Rebuild(AllEnemies)
+	hordeMode = aliveEnemies() > flowFieldHorde
+	if hordeMode {
+		tileMap.UpdateFlow(*g.Player.Pos)
+	}
 	for _, enemy := range AllEnemies {
 		enemy.Update(dt, &g.Player)
 	}
@@ -127,15 +122,8 @@ func (g *Game) drawScene(dst *ebiten.Image) {
 	ebitenutil.DrawRect(dst, 0, 0, float64(g.ScreenWidth), float64(g.ScreenHeight),
 		color.RGBA{R: 0, G: 100, B: 200, A: 255})
 
-	// draw the tiles
-	for i := range tileLayer {
-		for j := range tileLayer[i] {
-			tile := tileLayer[i][j]
-			op := &ebiten.DrawImageOptions{}
-			op.GeoM.Translate(float64(j*tileW), float64(i*tileW))
-			dst.DrawImage(tile, op)
-		}
-	}
+	// draw the map
+	tileMap.Draw(dst)
 
 	// render all enemies
 	for _, enemy := range AllEnemies {
@@ -158,12 +146,11 @@ func (g *Game) drawScene(dst *ebiten.Image) {
 
 	frame := heroAnimationManager.GetCurrentFrame()
 	op := &ebiten.DrawImageOptions{}
-	// figure out how to scale it to 64
+	// figure out how to scale it to 64, oversize frames (e.g. thrust_oversize) stay centered
 	tgtWidth := float64(g.Player.Width)
-	l := frame.Bounds().Dx()
-	s := float64(tgtWidth) / float64(l)
+	s := tgtWidth / float64(heroAnimationManager.FrameSize)
 	op.GeoM.Scale(s, s)
-	op.GeoM.Translate(-tgtWidth/2, -tgtWidth/2)
+	op.GeoM.Translate(-float64(frame.Bounds().Dx())*s/2, -float64(frame.Bounds().Dy())*s/2)
 	op.GeoM.Translate(float64(g.Player.Pos.X), float64(g.Player.Pos.Y))
 	dst.DrawImage(frame, op)
 	// draw a little dot to denote player position
@@ -173,6 +160,7 @@ func (g *Game) drawScene(dst *ebiten.Image) {
 	for _, w := range g.Player.Weapons {
 		w.ParticleEmitter.Draw(dst)
 	}
+	particleManager.Draw(dst)
 
 	toolbarRowSpacing := float64(32)
 	heartSpacing := 35
@@ -234,20 +222,34 @@ func (g *Game) Draw(screen *ebiten.Image) {
 		Images:   [4]*ebiten.Image{g.off, g.off, g.off, g.off}, // imageSrc0
 		Uniforms: uniforms,
 	}
-	screen.DrawRectShader(g.ScreenWidth, g.ScreenHeight, g.retroShader, op)
+	if g.retroShader != nil {
+		screen.DrawRectShader(g.ScreenWidth, g.ScreenHeight, g.retroShader, op)
+	} else {
+		// shader never compiled (dev mode), show the scene unfiltered
+		screen.DrawImage(g.off, nil)
+	}
 
 	// debug
 	actualTPS := ebiten.CurrentTPS()
 
 	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Actual TPS: %f", actualTPS), 10, 10)
 	numProjectiles := 0
-	numParticles := 0
 	for _, w := range g.Player.Weapons {
 		numProjectiles += len(w.Projectiles)
-		numParticles += len(w.ParticleEmitter.Particles)
 	}
+	// weapon trails and spawned effects, everything sharing the budget
+	numParticles := particleManager.NumParticles()
 	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Num Projectiles: %d", numProjectiles), 10, 30)
-	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Num Particles: %.2fK", float32(numParticles)/1000), 10, 50)
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Num Particles: %.2fK / %.2fK", float32(numParticles)/1000, float32(particleManager.Budget)/1000), 10, 50)
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Particle Quality (F2): %s x%.2f", particleManager.Quality, particleManager.AdaptiveScale), 10, 70)
+
+	if g.shaderErr != nil {
+		ebitenutil.DebugPrintAt(screen, g.shaderErr.Error(), 10, g.ScreenHeight/2)
+	}
+	if g.devStatus != "" {
+		ebitenutil.DebugPrintAt(screen, g.devStatus, 10, 90)
+	}
+	g.stateDebugger.Draw(screen, g)
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
@@ -256,20 +258,37 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeigh
 }
 
 func StartGame() {
-	Init()
+	if err := Init(); err != nil {
+		log.Fatalf("%v\nrun `go run ./cmd/validateassets` for a report of every missing or broken asset", err)
+	}
 
 	ebiten.SetWindowSize(logicalW*scale, logicalH*scale)
 	ebiten.SetWindowTitle("Smoke Particles Demo")
 	ebiten.SetTPS(int(TargetTPS))
 
+	particleManager = NewParticleManager(20000, QualityHigh)
+	fileWatcher = NewFileWatcher(assetFS, 1)
+	fileWatcher.WatchDir(effectsPath, ".json", particleManager.ReloadEffect)
+
+	// weapon trails, a missing effect is as fatal as a missing sprite
+	trails := make(map[string]*SmokeEmitter)
+	for _, name := range []string{"earth_trail", "fire_trail", "smoke_trail"} {
+		e, err := particleManager.NewEffectEmitter(name, 20000)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trails[name] = e
+	}
+
 	defaultCooldown := float32(.5)
 	defaultGas := float32(150)
 	earthProjectile := Projectile{
-		Pos:    Vec2Zero,
-		Dir:    Vec2Zero,
-		Speed:  160, // px/sec
-		Radius: 5,
-		Gas:    defaultGas, // how far can it has left to travel
+		Pos:       Vec2Zero,
+		Dir:       Vec2Zero,
+		Speed:     160, // px/sec
+		Radius:    5,
+		Gas:       defaultGas, // how far can it has left to travel
+		Knockback: 150,        // heavy rocks
 	}
 
 	earthWeapon := Weapon{
@@ -277,16 +296,18 @@ func StartGame() {
 		Projectiles:        []*Projectile{},
 		ProjectileInstance: &earthProjectile,
 		LastDir:            &Vec2{0.5, 0.5},
-		ParticleEmitter:    NewSmokeEmitter(earthImage, 20000, .1, 1),
+		ParticleEmitter:    trails["earth_trail"],
+		CastClip:           "spellcast",
 		TimeSinceFire:      rand.Float32() * defaultCooldown, // stagger fire times
 	}
 
 	fireProjectile := Projectile{
-		Pos:    Vec2Zero,
-		Dir:    Vec2Zero,
-		Speed:  200, // px/sec
-		Radius: 5,
-		Gas:    defaultGas, // how far can it has left to travel
+		Pos:       Vec2Zero,
+		Dir:       Vec2Zero,
+		Speed:     200, // px/sec
+		Radius:    5,
+		Gas:       defaultGas, // how far can it has left to travel
+		Knockback: 80,
 	}
 
 	fireWeapon := Weapon{
@@ -294,16 +315,18 @@ func StartGame() {
 		Projectiles:        []*Projectile{},
 		ProjectileInstance: &fireProjectile,
 		LastDir:            &Vec2{0.5, 0.5},
-		ParticleEmitter:    NewSmokeEmitter(fireImage, 20000, .1, .5),
+		ParticleEmitter:    trails["fire_trail"],
+		CastClip:           "spellcast",
 		TimeSinceFire:      defaultCooldown, // stagger fire times
 	}
 
 	smokeProjectile := Projectile{
-		Pos:    Vec2Zero,
-		Dir:    Vec2Zero,
-		Speed:  160, // px/sec
-		Radius: 5,
-		Gas:    defaultGas,
+		Pos:       Vec2Zero,
+		Dir:       Vec2Zero,
+		Speed:     160, // px/sec
+		Radius:    5,
+		Gas:       defaultGas,
+		Knockback: 30,
 	}
 
 	smokeWeapon := Weapon{
@@ -311,14 +334,19 @@ func StartGame() {
 		Projectiles:        []*Projectile{},
 		ProjectileInstance: &smokeProjectile,
 		LastDir:            &Vec2{0.5, 0.5},
-		ParticleEmitter:    NewSmokeEmitter(smokeImage, 20000, .1, 1),
+		ParticleEmitter:    trails["smoke_trail"],
+		CastClip:           "shoot",
 		TimeSinceFire:      rand.Float32() * defaultCooldown, // stagger fire times
 	}
 
 	_, _ = smokeWeapon, earthWeapon // silence unused
 
+	playerPos := Vec2{X: 100, Y: 100}
+	if spawn, ok := tileMap.SpawnPoint("player", 0); ok {
+		playerPos = spawn
+	}
 	player := Player{
-		Pos:                  &Vec2{X: 100, Y: 100},
+		Pos:                  &playerPos,
 		MoveDirection:        Vec2Zero,
 		AimDirection:         Vec2Zero,
 		Speed:                70, // px/sec
@@ -338,20 +366,37 @@ func StartGame() {
 		StrifeTime:           0, // current time left in strife
 		Width:                64,
 		ProjectileGrid:       NewProjectileGrid(64 / 4),
+		HurtCooldown:         1, // invulnerable for this long after a hit
 	}
 
 	// -- Set up animators --
-	heroAnimationManager = NewCharacterWalkingAnimator(heroImagePath)
-	statusBarAnimationManager = NewStatusBarAnimationManager("assets/toolbar/health.png", "assets/toolbar/mana.png", "assets/toolbar/stamina.png", player.MaxHealth, player.MaxMana, player.MaxStamina)
+	var err error
+	if player.Character, err = loadHeroCharacter(heroCharacterDir); err != nil {
+		log.Fatal(err)
+	}
+	heroAnimationManager, err = NewCharacterWalkingAnimator(heroManifestPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	statusBarAnimationManager, err = NewStatusBarAnimationManager(model.HealthSheet, model.ManaSheet, model.StaminaSheet, player.MaxHealth, player.MaxMana, player.MaxStamina)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	statusBarAnimationManager.DecrementHeart(900, HealthStatus)
 	statusBarAnimationManager.IncrementHeart(3, HealthStatus)
 
-	// render a couple skeletons randomly on screen
-	for i := 0; i < 5; i++ {
-		x := float32(rand.Intn(logicalW))
-		y := float32(rand.Intn(logicalH))
-		NewSkeletonEnemy(&Vec2{X: x, Y: y})
+	// spawn the skeletons at the map's spawn points, randomly on screen if it has none
+	for i := 0; i < skeletonCount; i++ {
+		pos, ok := tileMap.SpawnPoint("skeleton", i)
+		// random ones are rerolled until they're clear of obstacles by a skeleton's footprint
+		for !ok {
+			pos = Vec2{X: float32(rand.Intn(logicalW)), Y: float32(rand.Intn(logicalH))}
+			ok = !tileMap.Blocks(pos, 64/4)
+		}
+		if _, err := NewSkeletonEnemy(&pos); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	game := &Game{
@@ -362,12 +407,14 @@ func StartGame() {
 	}
 
 	GameInstance = game
+	game.Player.SubscribeAnimationEvents(heroAnimationManager)
 
-	sh, err := ebiten.NewShader([]byte(retroShaderSrc))
-	if err != nil {
+	if err := game.compileShader(retroShaderSrc); err != nil && !DevMode {
 		log.Fatal(err)
 	}
-	game.retroShader = sh
+	if DevMode {
+		game.enableHotReload()
+	}
 
 	if err := ebiten.RunGame(game); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,81 @@
+/*
+This file contains the development mode hot reload: sprite sheets and tiles are redrawn in place when
+their files change, the map is reloaded when it's saved in Tiled, and the retro shader is recompiled
+from source without restarting the game.
+*/
+package scripts
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// DevMode enables hot reloading of sprites, tiles and shaders (set with -dev).
+var DevMode bool
+
+// read from the source tree, the embedded copy in retroShaderSrc can't change at runtime
+const retroShaderPath = "scripts/shaders/retro.kage"
+
+func (g *Game) enableHotReload() {
+	fileWatcher.Interval = .5
+	assetManager.EnableHotReload(fileWatcher)
+	assetManager.OnReload = func(path string, err error) {
+		if err != nil {
+			g.devStatus = err.Error()
+		} else {
+			g.devStatus = "reloaded " + path
+		}
+		log.Print(g.devStatus)
+	}
+
+	fileWatcher.Watch(tileMap.Path, g.reloadMap)
+
+	g.shaderWatcher = NewFileWatcher(os.DirFS("."), .5)
+	g.shaderWatcher.Watch(retroShaderPath, g.reloadShader)
+}
+
+func (g *Game) reloadShader(path string) {
+	src, err := fs.ReadFile(g.shaderWatcher.FS, path)
+	if err != nil {
+		g.shaderErr = err
+		return
+	}
+	if err := g.compileShader(src); err != nil {
+		log.Print(err)
+		return
+	}
+	g.devStatus = "reloaded " + path
+	log.Print(g.devStatus)
+}
+
+// reloadMap swaps in the edited map. Spawn points only apply to the next game.
+func (g *Game) reloadMap(path string) {
+	m, err := LoadTileMap(path)
+	if err != nil {
+		g.devStatus = err.Error()
+	} else {
+		tileMap = m
+		g.devStatus = "reloaded " + path
+	}
+	log.Print(g.devStatus)
+}
+
+// compileShader swaps in the compiled shader. On failure the previous shader keeps running
+// and the error is shown on screen until the next successful compile.
+func (g *Game) compileShader(src []byte) error {
+	sh, err := ebiten.NewShader(src)
+	if err != nil {
+		g.shaderErr = fmt.Errorf("retro shader: %w", err)
+		return g.shaderErr
+	}
+	if g.retroShader != nil {
+		g.retroShader.Deallocate()
+	}
+	g.retroShader = sh
+	g.shaderErr = nil
+	return nil
+}
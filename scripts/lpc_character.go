@@ -0,0 +1,196 @@
+/*
+This file contains the LPC character compositor. It reads a character.json exported by the Universal LPC
+Spritesheet Character Generator and draws the character's layers, bottom to top, into one sheet per
+animation. The sheets replace the exported standard/ and custom/ sheets in the asset manager, so animation
+manifests pointing at those files play the composite, and swapping equipment redraws them in place.
+
+Layer sheets are read from the character's layers/ directory (a copy of the generator's spritesheets/).
+When a layer is missing there the exported, pre-composited sheet is used as is.
+*/
+package scripts
+
+import (
+	"fmt"
+	"game/model"
+	"image"
+	"io/fs"
+	"log"
+	pathpkg "path"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const lpcCharacterFile = "character.json"
+
+type LPCCharacter struct {
+	*model.LPCCharacter
+	Dir       string // directory of character.json, standard/ and custom/
+	LayersDir string // directory of the layer sheets
+
+	// sheets (relative to Dir) that use the exported sheet, because some of their layers are missing
+	Prebaked []string
+
+	// the slot ToggleTopSlot took off, and its layers
+	stowedSlot string
+	stowed     []model.LPCLayer
+}
+
+func LoadLPCCharacter(dir string) (*LPCCharacter, error) {
+	p := pathpkg.Join(dir, lpcCharacterFile)
+	data, err := fs.ReadFile(assetFS, p)
+	if err != nil {
+		return nil, &AssetError{Path: p, Err: err, Hint: missingHint(p, err)}
+	}
+	def, err := model.ParseLPCCharacter(data)
+	if err != nil {
+		return nil, &AssetError{Path: p, Err: err}
+	}
+	return &LPCCharacter{
+		LPCCharacter: def,
+		Dir:          dir,
+		LayersDir:    pathpkg.Join(dir, "layers"),
+	}, nil
+}
+
+// HasCharacterFile reports whether dir holds an LPC character.json.
+func HasCharacterFile(dir string) bool {
+	_, err := fs.Stat(assetFS, pathpkg.Join(dir, lpcCharacterFile))
+	return err == nil
+}
+
+// Composite draws every standard animation, and the custom animations the layers use.
+func (c *LPCCharacter) Composite() error {
+	c.Prebaked = nil
+	for i := range model.LPCAnimations {
+		anim := &model.LPCAnimations[i]
+		if err := c.compositeSheet(anim.StandardSheet(), anim, nil); err != nil {
+			return err
+		}
+	}
+	for i := range model.LPCCustomAnimations {
+		custom := &model.LPCCustomAnimations[i]
+		if !c.usesCustom(custom.Name) {
+			continue
+		}
+		if err := c.compositeSheet(custom.CustomSheet(), model.LPCAnimationNamed(custom.Base), custom); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Equip swaps the layers in a slot (e.g. "weapon" or "hat") and redraws the sheets.
+// No layers takes the slot off.
+func (c *LPCCharacter) Equip(parentName string, layers ...model.LPCLayer) error {
+	c.SetSlot(parentName, layers...)
+	return c.Composite()
+}
+
+// ToggleTopSlot takes off the slot of the top layer, e.g. the wizard's staff, or puts back the one it
+// took off last.
+func (c *LPCCharacter) ToggleTopSlot() error {
+	if c.stowedSlot != "" {
+		slot, layers := c.stowedSlot, c.stowed
+		c.stowedSlot, c.stowed = "", nil
+		return c.Equip(slot, layers...)
+	}
+	layers := c.SortedLayers()
+	if len(layers) == 0 {
+		return nil
+	}
+	c.stowedSlot = layers[len(layers)-1].ParentName
+	c.stowed = c.LayersFor(c.stowedSlot)
+	return c.Equip(c.stowedSlot)
+}
+
+func (c *LPCCharacter) usesCustom(name string) bool {
+	for _, layer := range c.Layers {
+		if layer.CustomAnimation == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compositeSheet draws anim's layers into the sheet at Dir/sheet. For custom animations, the standard
+// layers are centered in the bigger frames and the custom layers drawn over the whole sheet.
+func (c *LPCCharacter) compositeSheet(sheet string, anim *model.LPCAnimation, custom *model.LPCCustomAnimation) error {
+	sheetPath := pathpkg.Join(c.Dir, sheet)
+	frameSize := model.LPCFrameSize
+	if custom != nil {
+		frameSize = custom.FrameSize
+	}
+	offset := float64(frameSize-model.LPCFrameSize) / 2
+
+	dst := ebiten.NewImage(anim.Frames*frameSize, anim.Rows*frameSize)
+	drawn := 0
+	for _, layer := range c.SortedLayers() {
+		if layer.CustomAnimation != "" {
+			if custom == nil || layer.CustomAnimation != custom.Name {
+				continue
+			}
+		} else if !layer.Supports(anim) {
+			continue
+		}
+
+		layerSheet, err := assetManager.Image(pathpkg.Join(c.LayersDir, layer.SheetFor(anim)))
+		if err != nil {
+			dst.Deallocate()
+			return c.usePrebaked(sheet, sheetPath)
+		}
+
+		if layer.CustomAnimation != "" {
+			dst.DrawImage(layerSheet, nil)
+		} else {
+			for row := 0; row < anim.Rows; row++ {
+				for col := 0; col < anim.Frames; col++ {
+					rect := image.Rect(col*model.LPCFrameSize, row*model.LPCFrameSize, (col+1)*model.LPCFrameSize, (row+1)*model.LPCFrameSize)
+					if !rect.In(layerSheet.Bounds()) {
+						continue
+					}
+					op := &ebiten.DrawImageOptions{}
+					op.GeoM.Translate(float64(col*frameSize)+offset, float64(row*frameSize)+offset)
+					dst.DrawImage(layerSheet.SubImage(rect).(*ebiten.Image), op)
+				}
+			}
+		}
+		drawn++
+	}
+	if drawn == 0 {
+		dst.Deallocate()
+		return c.usePrebaked(sheet, sheetPath)
+	}
+
+	assetManager.Set(sheetPath, dst)
+	return nil
+}
+
+// usePrebaked falls back to the exported sheet, restoring its pixels if a composite was drawn over it.
+func (c *LPCCharacter) usePrebaked(sheet string, sheetPath string) error {
+	if err := assetManager.Reload(sheetPath); err != nil {
+		return err
+	}
+	if _, err := assetManager.Image(sheetPath); err != nil {
+		return fmt.Errorf("%s: layers missing and no exported sheet: %w", c.Dir, err)
+	}
+	c.Prebaked = append(c.Prebaked, sheet)
+	return nil
+}
+
+// loadHeroCharacter composites the hero's LPC layers, if its directory has a character.json.
+func loadHeroCharacter(dir string) (*LPCCharacter, error) {
+	if dir == "" || !HasCharacterFile(dir) {
+		return nil, nil
+	}
+	c, err := LoadLPCCharacter(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Composite(); err != nil {
+		return nil, err
+	}
+	if len(c.Prebaked) > 0 {
+		log.Printf("%s: %d sheets use the exported composite, add the layer sheets to %s to build them", dir, len(c.Prebaked), c.LayersDir)
+	}
+	return c, nil
+}
@@ -0,0 +1,72 @@
+/*
+This file contains the static obstacles of the map (see model.TiledMap.Colliders): trees, fences, stones and
+anything in a collision layer. They are bucketed in a grid like projectiles are, so a query only looks at
+the obstacles around it.
+*/
+package scripts
+
+import (
+	"game/model"
+	"image"
+	"math"
+)
+
+// Obstacle is a static collider. Characters never walk through it; projectiles stop on it only if
+// BlocksProjectiles is set, otherwise they fly over (logs, fences, low stones).
+type Obstacle struct {
+	model.Collider
+}
+
+// overlaps reports whether a square of half size radius centered on pos overlaps the obstacle.
+func (o *Obstacle) overlaps(pos Vec2, radius float32) bool {
+	r := o.Rect
+	return pos.X+radius > float32(r.Min.X) && pos.X-radius < float32(r.Max.X) &&
+		pos.Y+radius > float32(r.Min.Y) && pos.Y-radius < float32(r.Max.Y)
+}
+
+type ObstacleGrid struct {
+	CellSize  int
+	Cells     map[image.Point][]*Obstacle
+	Obstacles []*Obstacle
+}
+
+func NewObstacleGrid(cellSize int, colliders []model.Collider) *ObstacleGrid {
+	og := &ObstacleGrid{
+		CellSize: cellSize,
+		Cells:    make(map[image.Point][]*Obstacle),
+	}
+	for _, c := range colliders {
+		o := &Obstacle{Collider: c}
+		og.Obstacles = append(og.Obstacles, o)
+		// an obstacle is in every cell it touches
+		min, max := og.cellAt(float32(c.Rect.Min.X), float32(c.Rect.Min.Y)), og.cellAt(float32(c.Rect.Max.X-1), float32(c.Rect.Max.Y-1))
+		for y := min.Y; y <= max.Y; y++ {
+			for x := min.X; x <= max.X; x++ {
+				cell := image.Point{X: x, Y: y}
+				og.Cells[cell] = append(og.Cells[cell], o)
+			}
+		}
+	}
+	return og
+}
+
+func (og *ObstacleGrid) cellAt(x float32, y float32) image.Point {
+	// floor, not truncate, so cells left of and above the map don't share cell 0
+	size := float64(og.CellSize)
+	return image.Point{X: int(math.Floor(float64(x) / size)), Y: int(math.Floor(float64(y) / size))}
+}
+
+// At returns the first obstacle a square of half size radius centered on pos overlaps that matches, nil if none.
+func (og *ObstacleGrid) At(pos Vec2, radius float32, match func(o *Obstacle) bool) *Obstacle {
+	min, max := og.cellAt(pos.X-radius, pos.Y-radius), og.cellAt(pos.X+radius, pos.Y+radius)
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			for _, o := range og.Cells[image.Point{X: x, Y: y}] {
+				if o.overlaps(pos, radius) && (match == nil || match(o)) {
+					return o
+				}
+			}
+		}
+	}
+	return nil
+}
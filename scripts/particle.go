@@ -26,55 +26,66 @@ type SmokeEmitter struct {
 	Particles    []SmokeParticle
 	MaxParticles int
 
-	// Tunables for "shooting star" feel
-	ScaleBase  float32 // base starting scale
-	ScaleVar   float32 // random extra start scale
-	Growth     float32 // scale growth per second
-	Damping    float32 // velocity damping per tick (e.g., 0.99)
-	SpinRange  float32 // max abs spin (rad/s)
-	AlphaCurve int     // 1=linear, 2=quad, 3=cubic
+	// Tunables (shape, forces, curves...). Shared, so tweaking the effect changes every emitter using it
+	*ParticleEffect
+	imagePath string // effect.Image that Img was loaded from, if any
 
-	// Directional trail settings
-	Spread   float32 // radians half-angle (e.g., 0.15)
-	Jitter   float32 // spawn jitter in px (e.g., 0.5)
-	Lifetime float32
+	// Continuous emission: while Source is set, Rate particles per second are emitted there
+	Source    *Vec2
+	SourceDir *Vec2
+	rateCarry float32
+
+	// Budget, set by the ParticleManager this emitter is registered with
+	manager     *ParticleManager
+	Allowance   int   // share of the global budget (only used when managed)
+	LastEmitPos *Vec2 // used to prioritise emitters near the camera
 }
 
 // NewSmokeEmitter creates a trail-style emitter with sensible defaults.
 func NewSmokeEmitter(img *ebiten.Image, max int, scale float32, lifetime float32) *SmokeEmitter {
-	return &SmokeEmitter{
-		Img:          img,
-		Particles:    make([]SmokeParticle, 0, max),
-		MaxParticles: max,
-
-		// shooting-star defaults (tight, directional)
-		ScaleBase:  scale * 0.28,
-		ScaleVar:   scale * 0.10,
-		Growth:     -0.03, // shrink
-		Damping:    0.95,  // gentle damping to slow down
-		SpinRange:  0.25,  // subtle rotation
-		AlphaCurve: 3,     // cubic fade
+	return NewEmitterFromEffect(img, max, TrailEffect(scale, lifetime))
+}
 
-		Spread:   0.05, // ~±10 degrees
-		Jitter:   0.5,  // sub-pixel to ~1px
-		Lifetime: lifetime,
+// NewEmitterFromEffect creates an emitter driven by the given effect.
+func NewEmitterFromEffect(img *ebiten.Image, max int, effect *ParticleEffect) *SmokeEmitter {
+	return &SmokeEmitter{
+		Img:            img,
+		Particles:      []SmokeParticle{}, // grows on demand, the manager decides how many are allowed
+		MaxParticles:   max,
+		ParticleEffect: effect,
 	}
 }
 
-// Emit keeps for compatibility (still works, but directional is preferred).
-// Emits with zero forward bias (randomized in a narrow cone around +X).
+// Emit spawns n particles at pos using the effect's shape.
+// Cone emitters have no direction here, so they emit in a narrow cone around +X.
 func (e *SmokeEmitter) Emit(pos *Vec2, n int) {
-	// default forward dir = +X
-	e.EmitDirectional(pos, &Vec2{1, 0}, n, 1.0)
+	switch e.Shape {
+	case ShapeBurst:
+		e.EmitBurst(pos, n)
+	case ShapeRing:
+		e.EmitRing(pos, n)
+	case ShapeRect, ShapeCircle:
+		e.EmitArea(pos, n)
+	default:
+		// default forward dir = +X
+		e.EmitDirectional(pos, &Vec2{1, 0}, n, 1.0)
+	}
+}
+
+// EmitEffect emits one Count's worth of the effect at pos. dir is only used by cone effects.
+func (e *SmokeEmitter) EmitEffect(pos *Vec2, dir *Vec2) {
+	if e.Shape == ShapeCone && dir != nil {
+		e.EmitDirectional(pos, dir, e.Count, e.Speed)
+		return
+	}
+	e.Emit(pos, e.Count)
 }
 
 // EmitDirectional spawns N particles forward along `dir` with a narrow spread.
 // `dir` should be normalized; `speedScale` lets you tie speed to projectile speed.
 func (e *SmokeEmitter) EmitDirectional(pos *Vec2, dir *Vec2, n int, speedScale float32) {
-	if e.Img == nil || n <= 0 {
-		return
-	}
-	if len(e.Particles) >= e.MaxParticles {
+	n = e.reserve(pos, n)
+	if n == 0 {
 		return
 	}
 
@@ -82,12 +93,6 @@ func (e *SmokeEmitter) EmitDirectional(pos *Vec2, dir *Vec2, n int, speedScale f
 	d := dir.Norm()
 	base := float32(math.Atan2(float64(d.Y), float64(d.X)))
 
-	// clamp how many we can add without realloc
-	space := e.MaxParticles - len(e.Particles)
-	if n > space {
-		n = space
-	}
-
 	for i := 0; i < n; i++ {
 		// angle within a narrow cone
 		ang := float64(base + (rand.Float32()*2-1)*e.Spread)
@@ -96,36 +101,130 @@ func (e *SmokeEmitter) EmitDirectional(pos *Vec2, dir *Vec2, n int, speedScale f
 		vx := float32(math.Cos(ang) * spd)
 		vy := float32(math.Sin(ang) * spd)
 
-		// slight jitter to avoid perfect overlap
-		jx := (rand.Float32()*2 - 1) * e.Jitter
-		jy := (rand.Float32()*2 - 1) * e.Jitter
+		e.spawn(pos, &Vec2{vx, vy})
+	}
+}
 
-		// lifetime: tight/starry trails look good with shorter life
-		life := e.Lifetime + e.Lifetime*rand.Float32()*0.5
+// EmitBurst spawns N particles flying out of pos in every direction.
+func (e *SmokeEmitter) EmitBurst(pos *Vec2, n int) {
+	n = e.reserve(pos, n)
+	for i := 0; i < n; i++ {
+		ang := rand.Float64() * 2 * math.Pi
+		e.spawn(pos, e.velocityAt(ang))
+	}
+}
 
-		startScale := e.ScaleBase + rand.Float32()*e.ScaleVar
-		spin := (rand.Float32()*2 - 1) * e.SpinRange
+// EmitRing spawns N particles evenly spaced on a ring of Radius, moving outward.
+func (e *SmokeEmitter) EmitRing(pos *Vec2, n int) {
+	n = e.reserve(pos, n)
+	for i := 0; i < n; i++ {
+		ang := 2 * math.Pi * float64(i) / float64(n)
+		offset := &Vec2{X: float32(math.Cos(ang)) * e.Radius, Y: float32(math.Sin(ang)) * e.Radius}
+		e.spawn(pos.Add(offset), e.velocityAt(ang))
+	}
+}
 
-		e.Particles = append(e.Particles, SmokeParticle{
-			Pos:   &Vec2{pos.X + jx, pos.Y + jy},
-			Vel:   &Vec2{vx, vy},
-			Life:  float32(life),
-			Max:   float32(life),
-			Scale: startScale,
-			Rot:   0,
-			Spin:  spin,
-		})
+// EmitArea spawns N particles at random points inside the effect's rectangle or circle.
+func (e *SmokeEmitter) EmitArea(pos *Vec2, n int) {
+	n = e.reserve(pos, n)
+	for i := 0; i < n; i++ {
+		var offset *Vec2
+		if e.Shape == ShapeRect {
+			offset = &Vec2{X: (rand.Float32() - 0.5) * e.Width, Y: (rand.Float32() - 0.5) * e.Height}
+		} else {
+			// sqrt keeps the distribution uniform over the disc
+			r := e.Radius * float32(math.Sqrt(rand.Float64()))
+			ang := rand.Float64() * 2 * math.Pi
+			offset = &Vec2{X: float32(math.Cos(ang)) * r, Y: float32(math.Sin(ang)) * r}
+		}
+		e.spawn(pos.Add(offset), e.velocityAt(rand.Float64()*2*math.Pi))
 	}
 }
 
-// Update advances all particles and culls dead ones.
+func (e *SmokeEmitter) velocityAt(ang float64) *Vec2 {
+	spd := float64(e.Speed + rand.Float32()*e.SpeedVar)
+	return &Vec2{X: float32(math.Cos(ang) * spd), Y: float32(math.Sin(ang) * spd)}
+}
+
+// reserve returns how many of the n requested particles may actually be spawned.
+func (e *SmokeEmitter) reserve(pos *Vec2, n int) int {
+	if e.Img == nil || n <= 0 {
+		return 0
+	}
+	e.LastEmitPos = pos
+
+	if e.manager != nil {
+		// scale by quality + adaptive rate, carrying the fraction over randomly so low rates still emit
+		scaled := float32(n) * e.manager.EmissionScale()
+		n = int(scaled)
+		if rand.Float32() < scaled-float32(n) {
+			n++
+		}
+	}
+
+	// clamp how many we can add within our share of the budget
+	space := e.limit() - len(e.Particles)
+	if n > space {
+		n = space
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (e *SmokeEmitter) spawn(pos *Vec2, vel *Vec2) {
+	// slight jitter to avoid perfect overlap
+	jx := (rand.Float32()*2 - 1) * e.Jitter
+	jy := (rand.Float32()*2 - 1) * e.Jitter
+
+	// lifetime: tight/starry trails look good with shorter life
+	life := e.Lifetime + e.Lifetime*rand.Float32()*0.5
+
+	startScale := e.ScaleBase + rand.Float32()*e.ScaleVar
+	spin := (rand.Float32()*2 - 1) * e.SpinRange
+
+	e.Particles = append(e.Particles, SmokeParticle{
+		Pos:   &Vec2{pos.X + jx, pos.Y + jy},
+		Vel:   vel,
+		Life:  float32(life),
+		Max:   float32(life),
+		Scale: startScale,
+		Rot:   0,
+		Spin:  spin,
+	})
+}
+
+// limit is the most particles this emitter may have alive right now.
+func (e *SmokeEmitter) limit() int {
+	if e.manager != nil && e.Allowance < e.MaxParticles {
+		return e.Allowance
+	}
+	return e.MaxParticles
+}
+
+// Update emits from the continuous Source, advances all particles and culls dead ones.
 func (e *SmokeEmitter) Update(dt float32) {
+	if e.Source != nil && e.Rate > 0 {
+		e.rateCarry += e.Rate * dt
+		n := int(e.rateCarry)
+		e.rateCarry -= float32(n)
+		if n > 0 {
+			if e.SourceDir != nil && e.Shape == ShapeCone {
+				e.EmitDirectional(e.Source, e.SourceDir, n, e.Speed)
+			} else {
+				e.Emit(e.Source, n)
+			}
+		}
+	}
+
 	if len(e.Particles) == 0 {
 		return
 	}
 	next := e.Particles[:0]
 	damp := e.Damping
 	grow := e.Growth
+	accel := e.Gravity.Add(&e.Wind).Mul(dt)
 
 	for i := 0; i < len(e.Particles); i++ {
 		p := e.Particles[i]
@@ -135,6 +234,8 @@ func (e *SmokeEmitter) Update(dt float32) {
 		}
 
 		// integrate
+		p.Vel.X += accel.X
+		p.Vel.Y += accel.Y
 		p.Pos = p.Pos.Add(p.Vel.Mul(dt))
 
 		// keep tight: small damping prevents wide spreading
@@ -157,17 +258,21 @@ func (e *SmokeEmitter) Draw(screen *ebiten.Image) {
 		return
 	}
 	w, h := e.Img.Size()
+	blend := e.Blend.ebitenBlend()
 	for i := 0; i < len(e.Particles); i++ {
 		p := &e.Particles[i]
 
+		// fade alpha by life with chosen curve
+		elapsed := 1.0 - (p.Life / p.Max) // 0..1
+		scale := p.Scale * sampleCurve(e.ScaleOverLife, elapsed)
+
 		op := &ebiten.DrawImageOptions{}
 		op.GeoM.Translate(-float64(w)/2, -float64(h)/2)
 		op.GeoM.Rotate(float64(p.Rot))
-		op.GeoM.Scale(float64(p.Scale), float64(p.Scale))
+		op.GeoM.Scale(float64(scale), float64(scale))
 		op.GeoM.Translate(float64(p.Pos.X), float64(p.Pos.Y))
+		op.Blend = blend
 
-		// fade alpha by life with chosen curve
-		elapsed := 1.0 - (p.Life / p.Max) // 0..1
 		a := float32(1.0)
 		switch e.AlphaCurve {
 		case 1: // linear
@@ -180,7 +285,8 @@ func (e *SmokeEmitter) Draw(screen *ebiten.Image) {
 		if a < 0 {
 			a = 0
 		}
-		op.ColorScale.Scale(1, 1, 1, float32(a))
+		c := sampleColor(e.ColorOverLife, elapsed)
+		op.ColorScale.Scale(c.R, c.G, c.B, c.A*a)
 
 		screen.DrawImage(e.Img, op)
 	}
@@ -0,0 +1,348 @@
+/*
+This file contains ParticleEffect, the tunables that describe how an emitter spawns, moves and draws
+its particles, plus the named presets used for gameplay effects.
+*/
+package scripts
+
+import (
+	"encoding/json"
+	"fmt"
+	"game/model"
+	"io/fs"
+	"log"
+	pathpkg "path"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Effects can also be described in JSON (see assets/effects). Files there override the presets below
+// and are re-read while the game runs, so trails can be tuned without recompiling.
+const effectsPath = model.EffectsDir
+
+type EmitterShape int
+
+const (
+	ShapeCone   EmitterShape = iota // narrow cone along a direction (trails)
+	ShapeBurst                      // all directions from a point
+	ShapeRing                       // evenly spaced on a ring, moving outward
+	ShapeRect                       // random points inside a Width x Height rectangle
+	ShapeCircle                     // random points inside a circle of Radius
+)
+
+var shapeNames = map[EmitterShape]string{
+	ShapeCone:   "cone",
+	ShapeBurst:  "burst",
+	ShapeRing:   "ring",
+	ShapeRect:   "rect",
+	ShapeCircle: "circle",
+}
+
+func (s EmitterShape) MarshalText() ([]byte, error) {
+	return []byte(shapeNames[s]), nil
+}
+
+func (s *EmitterShape) UnmarshalText(text []byte) error {
+	for shape, name := range shapeNames {
+		if name == string(text) {
+			*s = shape
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown emitter shape %q", text)
+}
+
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendAdditive
+)
+
+func (b BlendMode) MarshalText() ([]byte, error) {
+	if b == BlendAdditive {
+		return []byte("additive"), nil
+	}
+	return []byte("normal"), nil
+}
+
+func (b *BlendMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "normal":
+		*b = BlendNormal
+	case "additive":
+		*b = BlendAdditive
+	default:
+		return fmt.Errorf("unknown blend mode %q", text)
+	}
+	return nil
+}
+
+func (b BlendMode) ebitenBlend() ebiten.Blend {
+	if b == BlendAdditive {
+		return ebiten.BlendLighter
+	}
+	return ebiten.BlendSourceOver
+}
+
+// ColorStop is a color at T (0 = spawn, 1 = death). Channels are 0..1.
+type ColorStop struct {
+	T float32 `json:"t"`
+	R float32 `json:"r"`
+	G float32 `json:"g"`
+	B float32 `json:"b"`
+	A float32 `json:"a"`
+}
+
+// CurveStop is a value at T (0 = spawn, 1 = death).
+type CurveStop struct {
+	T float32 `json:"t"`
+	V float32 `json:"v"`
+}
+
+type ParticleEffect struct {
+	Name  string `json:"name"`
+	Image string `json:"image"` // sprite used when the effect is spawned by name
+
+	// Spawning
+	Shape    EmitterShape `json:"shape"`
+	Count    int          `json:"count"`    // particles per Emit call for burst/ring/area shapes
+	Rate     float32      `json:"rate"`     // particles per second while the emitter has a Source (0 = on demand only)
+	Speed    float32      `json:"speed"`    // initial speed (px/sec) for burst/ring/area shapes
+	SpeedVar float32      `json:"speedVar"` // random extra speed
+	Radius   float32      `json:"radius"`   // ring/circle radius
+	Width    float32      `json:"width"`    // rect area size
+	Height   float32      `json:"height"`
+
+	// Tunables for "shooting star" feel
+	ScaleBase  float32 `json:"scaleBase"`  // base starting scale
+	ScaleVar   float32 `json:"scaleVar"`   // random extra start scale
+	Growth     float32 `json:"growth"`     // scale growth per second
+	Damping    float32 `json:"damping"`    // velocity damping per tick (e.g., 0.99)
+	SpinRange  float32 `json:"spinRange"`  // max abs spin (rad/s)
+	AlphaCurve int     `json:"alphaCurve"` // 1=linear, 2=quad, 3=cubic
+
+	// Directional trail settings
+	Spread   float32 `json:"spread"` // radians half-angle (e.g., 0.15)
+	Jitter   float32 `json:"jitter"` // spawn jitter in px (e.g., 0.5)
+	Lifetime float32 `json:"lifetime"`
+
+	// Forces (px/sec^2)
+	Gravity Vec2 `json:"gravity"`
+	Wind    Vec2 `json:"wind"`
+
+	// Over-life curves, empty means constant
+	ColorOverLife []ColorStop `json:"colorOverLife"`
+	ScaleOverLife []CurveStop `json:"scaleOverLife"` // multiplies the particle's scale
+
+	Blend BlendMode `json:"blend"`
+}
+
+// TrailEffect is the tight, directional trail left behind projectiles.
+func TrailEffect(scale float32, lifetime float32) *ParticleEffect {
+	return &ParticleEffect{
+		Name:  "trail",
+		Shape: ShapeCone,
+		Count: 1,
+
+		// shooting-star defaults (tight, directional)
+		ScaleBase:  scale * 0.28,
+		ScaleVar:   scale * 0.10,
+		Growth:     -0.03, // shrink
+		Damping:    0.95,  // gentle damping to slow down
+		SpinRange:  0.25,  // subtle rotation
+		AlphaCurve: 3,     // cubic fade
+
+		Spread:   0.05, // ~±10 degrees
+		Jitter:   0.5,  // sub-pixel to ~1px
+		Lifetime: lifetime,
+	}
+}
+
+func namedTrail(name string, image string, scale float32, lifetime float32) *ParticleEffect {
+	effect := TrailEffect(scale, lifetime)
+	effect.Name = name
+	effect.Image = image
+	return effect
+}
+
+// ParticleEffects are the named presets, spawned with ParticleManager.Spawn.
+var ParticleEffects = map[string]*ParticleEffect{
+	"earth_trail": namedTrail("earth_trail", "assets/earth.png", .1, 1),
+	"fire_trail":  namedTrail("fire_trail", "assets/fire.png", .1, .5),
+	"smoke_trail": namedTrail("smoke_trail", "assets/smoke.png", .1, 1),
+	"hit_spark": {
+		Name:       "hit_spark",
+		Image:      "assets/fire.png",
+		Shape:      ShapeBurst,
+		Count:      8,
+		Speed:      90,
+		SpeedVar:   60,
+		ScaleBase:  0.02,
+		ScaleVar:   0.01,
+		Damping:    0.92,
+		SpinRange:  2,
+		AlphaCurve: 1,
+		Jitter:     1,
+		Lifetime:   0.2,
+		Gravity:    Vec2{X: 0, Y: 200},
+		ColorOverLife: []ColorStop{
+			{T: 0, R: 1, G: 1, B: 0.6, A: 1},
+			{T: 0.5, R: 1, G: 0.6, B: 0.1, A: 1},
+			{T: 1, R: 0.8, G: 0.1, B: 0, A: 0},
+		},
+		ScaleOverLife: []CurveStop{{T: 0, V: 1}, {T: 1, V: 0.3}},
+		Blend:         BlendAdditive,
+	},
+	"footstep_dust": {
+		Name:       "footstep_dust",
+		Image:      "assets/earth.png",
+		Shape:      ShapeRect,
+		Count:      4,
+		Speed:      8,
+		SpeedVar:   8,
+		Width:      10,
+		Height:     2,
+		ScaleBase:  0.008,
+		ScaleVar:   0.004,
+		Growth:     0.004,
+		Damping:    0.9,
+		SpinRange:  0.5,
+		AlphaCurve: 2,
+		Jitter:     0.5,
+		Lifetime:   0.35,
+		Wind:       Vec2{X: 0, Y: -10},
+		ColorOverLife: []ColorStop{
+			{T: 0, R: 0.8, G: 0.7, B: 0.55, A: 0.8},
+			{T: 1, R: 0.6, G: 0.5, B: 0.4, A: 0},
+		},
+		Blend: BlendNormal,
+	},
+	"death_poof": {
+		Name:       "death_poof",
+		Image:      "assets/smoke.png",
+		Shape:      ShapeCircle,
+		Count:      24,
+		Speed:      15,
+		SpeedVar:   20,
+		Radius:     16,
+		ScaleBase:  0.04,
+		ScaleVar:   0.03,
+		Growth:     0.05,
+		Damping:    0.97,
+		SpinRange:  0.5,
+		AlphaCurve: 2,
+		Jitter:     2,
+		Lifetime:   0.8,
+		Wind:       Vec2{X: 0, Y: -20}, // drifts upward
+		ColorOverLife: []ColorStop{
+			{T: 0, R: 0.9, G: 0.9, B: 0.9, A: 1},
+			{T: 1, R: 0.4, G: 0.4, B: 0.45, A: 0},
+		},
+		Blend: BlendNormal,
+	},
+	"level_up_burst": {
+		Name:       "level_up_burst",
+		Image:      "assets/fire.png",
+		Shape:      ShapeRing,
+		Count:      32,
+		Speed:      120,
+		SpeedVar:   10,
+		Radius:     8,
+		ScaleBase:  0.025,
+		ScaleVar:   0.005,
+		Damping:    0.96,
+		SpinRange:  1,
+		AlphaCurve: 1,
+		Lifetime:   0.7,
+		Gravity:    Vec2{X: 0, Y: -60},
+		ColorOverLife: []ColorStop{
+			{T: 0, R: 1, G: 1, B: 1, A: 1},
+			{T: 0.4, R: 1, G: 0.85, B: 0.3, A: 1},
+			{T: 1, R: 1, G: 0.6, B: 0.1, A: 0},
+		},
+		ScaleOverLife: []CurveStop{{T: 0, V: 0.5}, {T: 0.2, V: 1.2}, {T: 1, V: 0.8}},
+		Blend:         BlendAdditive,
+	},
+}
+
+// sampleColor returns the color at t, linearly interpolated between stops.
+func sampleColor(stops []ColorStop, t float32) ColorStop {
+	if len(stops) == 0 {
+		return ColorStop{T: t, R: 1, G: 1, B: 1, A: 1}
+	}
+	if t <= stops[0].T {
+		return stops[0]
+	}
+	for i := 1; i < len(stops); i++ {
+		a, b := stops[i-1], stops[i]
+		if t <= b.T {
+			f := (t - a.T) / (b.T - a.T)
+			return ColorStop{
+				T: t,
+				R: a.R + (b.R-a.R)*f,
+				G: a.G + (b.G-a.G)*f,
+				B: a.B + (b.B-a.B)*f,
+				A: a.A + (b.A-a.A)*f,
+			}
+		}
+	}
+	return stops[len(stops)-1]
+}
+
+// sampleCurve returns the value at t, linearly interpolated between stops.
+func sampleCurve(stops []CurveStop, t float32) float32 {
+	if len(stops) == 0 {
+		return 1
+	}
+	if t <= stops[0].T {
+		return stops[0].V
+	}
+	for i := 1; i < len(stops); i++ {
+		a, b := stops[i-1], stops[i]
+		if t <= b.T {
+			return a.V + (b.V-a.V)*(t-a.T)/(b.T-a.T)
+		}
+	}
+	return stops[len(stops)-1].V
+}
+
+// LoadParticleEffect reads an effect from a JSON file. The name defaults to the file name.
+func LoadParticleEffect(path string) (*ParticleEffect, error) {
+	data, err := fs.ReadFile(assetFS, path)
+	if err != nil {
+		return nil, err
+	}
+	effect := &ParticleEffect{}
+	if err := json.Unmarshal(data, effect); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if effect.Name == "" {
+		effect.Name = strings.TrimSuffix(pathpkg.Base(path), pathpkg.Ext(path))
+	}
+	return effect, nil
+}
+
+// ApplyParticleEffect registers effect under its name. If an effect with that name already exists it is
+// overwritten in place, so every emitter using it picks up the new tunables immediately.
+func ApplyParticleEffect(effect *ParticleEffect) *ParticleEffect {
+	existing, ok := ParticleEffects[effect.Name]
+	if !ok {
+		ParticleEffects[effect.Name] = effect
+		return effect
+	}
+	*existing = *effect
+	return existing
+}
+
+// LoadParticleEffects applies every effect file in dir. Bad files are logged and skipped.
+func LoadParticleEffects(dir string) {
+	for _, path := range listDir(assetFS, dir, ".json") {
+		effect, err := LoadParticleEffect(path)
+		if err != nil {
+			log.Printf("particles: %v", err)
+			continue
+		}
+		ApplyParticleEffect(effect)
+	}
+}
@@ -0,0 +1,239 @@
+/*
+This file contains the ParticleManager, which owns the particle budget shared by every emitter
+and scales emission down when the game can't keep up with TargetTPS.
+*/
+package scripts
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	pathpkg "path"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+type ParticleQuality int
+
+const (
+	QualityLow ParticleQuality = iota
+	QualityMedium
+	QualityHigh
+)
+
+func (q ParticleQuality) String() string {
+	switch q {
+	case QualityLow:
+		return "low"
+	case QualityMedium:
+		return "medium"
+	case QualityHigh:
+		return "high"
+	}
+	return "unknown"
+}
+
+// fraction of the budget and emission rate each quality level allows
+func (q ParticleQuality) scale() float32 {
+	switch q {
+	case QualityLow:
+		return 0.25
+	case QualityMedium:
+		return 0.6
+	}
+	return 1
+}
+
+type ParticleManager struct {
+	Budget   int // max live particles across all emitters
+	Quality  ParticleQuality
+	Emitters []*SmokeEmitter
+
+	// One emitter per named effect, created on first Spawn and owned (updated + drawn) by the manager
+	effectEmitters map[string]*SmokeEmitter
+	effectOrder    []*SmokeEmitter
+
+	// Priority falloff: an emitter this many px from the focus gets half the share of one at the focus
+	FocusFalloff float32
+	focus        *Vec2 // from the last Update
+
+	// Adaptive quality
+	AdaptiveScale float32 // 0..1, lowered while TPS is below target
+	MinAdaptive   float32
+	TPSThreshold  float64 // fraction of TargetTPS below which we back off (e.g., 0.9)
+	BackoffRate   float32 // scale lost per second while slow
+	RecoverRate   float32 // scale regained per second while fast
+}
+
+func NewParticleManager(budget int, quality ParticleQuality) *ParticleManager {
+	return &ParticleManager{
+		Budget:         budget,
+		Quality:        quality,
+		Emitters:       []*SmokeEmitter{},
+		effectEmitters: make(map[string]*SmokeEmitter),
+		FocusFalloff:   400,
+		AdaptiveScale:  1,
+		MinAdaptive:    0.1,
+		TPSThreshold:   0.9,
+		BackoffRate:    0.5,
+		RecoverRate:    0.25,
+	}
+}
+
+// Register hands the emitter's budget over to the manager and returns it for chaining.
+func (pm *ParticleManager) Register(e *SmokeEmitter) *SmokeEmitter {
+	e.manager = pm
+	e.Allowance = 0
+	pm.Emitters = append(pm.Emitters, e)
+	return e
+}
+
+func (pm *ParticleManager) Unregister(e *SmokeEmitter) {
+	for i, other := range pm.Emitters {
+		if other == e {
+			pm.Emitters = append(pm.Emitters[:i], pm.Emitters[i+1:]...)
+			break
+		}
+	}
+	e.manager = nil
+}
+
+// NewEffectEmitter creates a managed emitter for the named effect, using the effect's image.
+func (pm *ParticleManager) NewEffectEmitter(name string, max int) (*SmokeEmitter, error) {
+	effect, ok := ParticleEffects[name]
+	if !ok {
+		path := pathpkg.Join(effectsPath, name+".json")
+		hint := missingHint(path, fs.ErrNotExist)
+		if _, err := fs.Stat(assetFS, path); err == nil {
+			hint = "the file is there but didn't load, see the log above"
+		}
+		return nil, &AssetError{Path: path, Err: fmt.Errorf("no particle effect %q", name), Hint: hint}
+	}
+	img, err := loadImage(effect.Image)
+	if err != nil {
+		return nil, fmt.Errorf("particle effect %q: %w", name, err)
+	}
+	e := NewEmitterFromEffect(img, max, effect)
+	e.imagePath = effect.Image
+	return pm.Register(e), nil
+}
+
+// Spawn plays the named effect preset at pos. dir is only used by cone effects and may be nil.
+func (pm *ParticleManager) Spawn(name string, pos *Vec2, dir *Vec2) {
+	e := pm.effectEmitters[name]
+	if e == nil {
+		var err error
+		if e, err = pm.NewEffectEmitter(name, pm.Budget); err != nil {
+			log.Printf("particles: %v", err)
+			return
+		}
+		pm.effectEmitters[name] = e
+		pm.effectOrder = append(pm.effectOrder, e)
+		// share the budget now, or the first effect would emit nothing until the next Update
+		e.LastEmitPos = pos
+		pm.allocate()
+	}
+	e.EmitEffect(pos, dir)
+}
+
+// ReloadEffect re-reads an effect file and applies it to every emitter using that effect.
+func (pm *ParticleManager) ReloadEffect(path string) {
+	loaded, err := LoadParticleEffect(path)
+	if err != nil {
+		// keep the old tunables, the file is probably mid-edit
+		log.Printf("particles: %v", err)
+		return
+	}
+	effect := ApplyParticleEffect(loaded)
+	for _, e := range pm.Emitters {
+		if e.ParticleEffect == effect && e.imagePath != "" && e.imagePath != effect.Image {
+			img, err := loadImage(effect.Image)
+			if err != nil {
+				log.Printf("particles: %s: %v", effect.Name, err)
+				continue
+			}
+			e.Img = img
+			e.imagePath = effect.Image
+		}
+	}
+	log.Printf("particles: reloaded %s", effect.Name)
+}
+
+// Draw renders the emitters created by Spawn. Weapon emitters are drawn by their owners.
+func (pm *ParticleManager) Draw(dst *ebiten.Image) {
+	for _, e := range pm.effectOrder {
+		e.Draw(dst)
+	}
+}
+
+func (pm *ParticleManager) CycleQuality() {
+	pm.Quality = (pm.Quality + 1) % (QualityHigh + 1)
+}
+
+// EmissionScale is the multiplier applied to every emit request.
+func (pm *ParticleManager) EmissionScale() float32 {
+	return pm.Quality.scale() * pm.AdaptiveScale
+}
+
+// NumParticles counts the live particles of every registered emitter, against Budget.
+func (pm *ParticleManager) NumParticles() int {
+	total := 0
+	for _, e := range pm.Emitters {
+		total += len(e.Particles)
+	}
+	return total
+}
+
+// Update adapts to the current TPS and splits the budget between emitters,
+// favouring the ones closest to focus (the camera center).
+func (pm *ParticleManager) Update(dt float32, focus *Vec2) {
+	tps := ebiten.CurrentTPS()
+	// CurrentTPS is 0 for the first second, don't punish startup
+	if tps > 0 && tps < TargetTPS*pm.TPSThreshold {
+		pm.AdaptiveScale -= pm.BackoffRate * dt
+	} else {
+		pm.AdaptiveScale += pm.RecoverRate * dt
+	}
+	pm.AdaptiveScale = float32(math.Max(float64(pm.MinAdaptive), math.Min(1, float64(pm.AdaptiveScale))))
+
+	for _, e := range pm.effectOrder {
+		e.Update(dt)
+	}
+
+	pm.focus = focus
+	pm.allocate()
+}
+
+// allocate splits the budget between emitters, favouring the ones closest to the focus.
+func (pm *ParticleManager) allocate() {
+	focus := pm.focus
+	if len(pm.Emitters) == 0 {
+		return
+	}
+
+	budget := float32(pm.Budget) * pm.EmissionScale()
+
+	priorities := make([]float32, len(pm.Emitters))
+	var total float32
+	for i, e := range pm.Emitters {
+		// emitters that never fired (e.g. unequipped weapons) don't need a share yet
+		priority := float32(0)
+		if e.LastEmitPos != nil {
+			priority = 1
+			if focus != nil {
+				priority = 1 / (1 + e.LastEmitPos.Distance(focus)/pm.FocusFalloff)
+			}
+		}
+		priorities[i] = priority
+		total += priority
+	}
+
+	for i, e := range pm.Emitters {
+		if total == 0 {
+			e.Allowance = int(budget) / len(pm.Emitters)
+			continue
+		}
+		e.Allowance = int(budget * priorities[i] / total)
+	}
+}
@@ -1,10 +1,12 @@
 package scripts
 
 import (
+	"log"
 	"math/rand"
 	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 type Player struct {
@@ -28,9 +30,68 @@ type Player struct {
 	StrifeDecay          float32 // loss of speed
 	Width                float32
 	ProjectileGrid       *ProjectileGrid
+	HurtCooldown         float32       // seconds invulnerable after a hit
+	hurtTimer            float32       // seconds of invulnerability left
+	Character            *LPCCharacter // composited layers of an LPC hero, nil when it plays plain sheets
+}
+
+// Hurt takes damage hearts of health, unless the player was hurt less than HurtCooldown ago.
+func (p *Player) Hurt(damage int) {
+	if p.hurtTimer > 0 {
+		return
+	}
+	p.hurtTimer = p.HurtCooldown
+	statusBarAnimationManager.DecrementHeart(damage, HealthStatus)
+	heroAnimationManager.Play("hurt")
+}
+
+// a fired weapon releases its projectile on the cast frame, or after this long if the frame never shows
+// (the cast clip was cut short, or the manifest has no "cast" event)
+const castReleaseSec = 0.6
+
+// SubscribeAnimationEvents hooks gameplay up to the hero's animation events.
+func (p *Player) SubscribeAnimationEvents(animator *WalkingAnimationManager) {
+	animator.Subscribe("cast", func(AnimationEvent) {
+		// the spell leaves the hands
+		for i := range p.Weapons {
+			if p.Weapons[i].charged {
+				p.release(&p.Weapons[i])
+			}
+		}
+	})
+	animator.Subscribe("footstep", func(AnimationEvent) {
+		// kick up dust at the feet
+		particleManager.Spawn("footstep_dust", p.Pos.Add(&Vec2{X: 0, Y: p.Width * 0.4}), nil)
+	})
+}
+
+// release fires a charged weapon's projectile toward where the player aims now.
+func (p *Player) release(w *Weapon) {
+	w.charged = false
+	newProj := *w.ProjectileInstance
+	newProj.Pos = p.Pos.Add(p.MoveDirection.Mul(32))
+
+	newProj.Dir = p.AimDirection.Norm()
+
+	// add some randomness
+	randomizedVec := &Vec2{X: (rand.Float32()*2 - 1) * 0.5, Y: (rand.Float32()*2 - 1) * 0.5}
+	randomizedVec = randomizedVec.Norm().Mul(.1)
+	newProj.Dir = newProj.Dir.Add(randomizedVec).Norm()
+
+	w.Projectiles = append(w.Projectiles, &newProj)
+	w.LastDir = p.AimDirection.Norm()
+
+	p.ProjectileGrid.AddProjectile(&newProj)
 }
 
 func (p *Player) Update(dt float32) {
+	p.hurtTimer -= dt
+	if p.Character != nil && inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		// take the outermost equipment off, or put it back on
+		if err := p.Character.ToggleTopSlot(); err != nil {
+			log.Print(err)
+		}
+	}
 	cursorX, cursorY := ebiten.CursorPosition()
 	cursor := &Vec2{float32(cursorX), float32(cursorY)}
 	if p.Pos.Distance(cursor) < 5 {
@@ -67,7 +128,7 @@ func (p *Player) Update(dt float32) {
 	// check if blocking (right clicking)
 	blocking := false
 	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) && p.StrifeTime <= 0 {
-		heroAnimationManager.UpdateByDirection(float64(p.AimDirection.X), float64(p.AimDirection.Y), time.Duration(dt*1000)*time.Millisecond, true, "block")
+		heroAnimationManager.UpdateByDirection(float64(p.AimDirection.X), float64(p.AimDirection.Y), time.Duration(dt*1000)*time.Millisecond, p.MoveDirection.Length() > 0, "block")
 		blocking = true
 		vel = vel.Mul(0.3) // slow down when blocking
 	}
@@ -104,16 +165,17 @@ func (p *Player) Update(dt float32) {
 		statusBarAnimationManager.DecrementHeart(1, StaminaStatus)
 	}
 
-	if p.Pos.Add(vel).IsInBounds(GameInstance.ScreenWidth, GameInstance.ScreenHeight, int(p.Width/4)) {
+	if p.canStandAt(p.Pos.Add(vel)) {
 		p.Pos = p.Pos.Add(vel)
-	} else if p.Pos.Add(&Vec2{X: vel.X}).IsInBounds(GameInstance.ScreenWidth, GameInstance.ScreenHeight, int(p.Width/4)) {
+	} else if p.canStandAt(p.Pos.Add(&Vec2{X: vel.X})) {
 		p.Pos = p.Pos.Add(&Vec2{X: vel.X})
-	} else if p.Pos.Add(&Vec2{Y: vel.Y}).IsInBounds(GameInstance.ScreenWidth, GameInstance.ScreenHeight, int(p.Width/4)) {
+	} else if p.canStandAt(p.Pos.Add(&Vec2{Y: vel.Y})) {
 		p.Pos = p.Pos.Add(&Vec2{Y: vel.Y})
 	}
 
 	// weapons & projectiles
 	shot := false
+	castClip := ""
 	for i := range p.Weapons {
 		w := &p.Weapons[i]
 		w.TimeSinceFire += dt
@@ -129,6 +191,12 @@ func (p *Player) Update(dt float32) {
 
 			w.ParticleEmitter.EmitDirectional(pr.Pos, pr.Dir, 2, pr.Speed)
 
+			// tall obstacles stop projectiles, low ones are shot over
+			if tileMap.ProjectileHit(*pr.Pos, pr.Radius) != nil {
+				particleManager.Spawn("hit_spark", pr.Pos, pr.Dir)
+				pr.Gas = 0
+			}
+
 			// keep if on-screen
 			if p.Pos.IsInBounds(GameInstance.ScreenWidth, GameInstance.ScreenHeight, 0) && pr.Gas > 0 {
 				newProjectiles = append(newProjectiles, pr)
@@ -144,24 +212,19 @@ func (p *Player) Update(dt float32) {
 		// fire when cooldown elapses if holding mouse button
 		hasMana := statusBarAnimationManager.HasHearts(ManaStatus)
 
-		if hasMana && w.TimeSinceFire >= w.CooldownSec && ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+		if hasMana && !w.charged && w.TimeSinceFire >= w.CooldownSec && ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
 			w.TimeSinceFire = 0 + (rand.Float32()*2-1)*0.1*w.CooldownSec // add some randomness to rate of fire
 			shot = true
-			newProj := *w.ProjectileInstance
-			newProj.Pos = p.Pos.Add(p.MoveDirection.Mul(32))
-
-			newProj.Dir = p.AimDirection.Norm()
-
-			// add some randomness
-			randomizedVec := &Vec2{X: (rand.Float32()*2 - 1) * 0.5, Y: (rand.Float32()*2 - 1) * 0.5}
-			randomizedVec = randomizedVec.Norm().Mul(.1)
-			newProj.Dir = newProj.Dir.Add(randomizedVec).Norm()
-
-			w.Projectiles = append(w.Projectiles, &newProj)
-			w.LastDir = p.AimDirection.Norm()
-
-			p.ProjectileGrid.AddProjectile(&newProj)
-
+			if castClip == "" {
+				castClip = w.CastClip
+			}
+			// the projectile leaves on the cast frame, see SubscribeAnimationEvents
+			w.charged, w.chargeTimer = true, 0
+		} else if w.charged {
+			w.chargeTimer += dt
+			if w.chargeTimer >= castReleaseSec {
+				p.release(w)
+			}
 		}
 		// Add the new projectile to the grid
 		w.ParticleEmitter.Update(dt)
@@ -169,6 +232,9 @@ func (p *Player) Update(dt float32) {
 
 	if shot {
 		statusBarAnimationManager.DecrementHeart(1, ManaStatus)
+		if castClip != "" {
+			heroAnimationManager.Play(castClip)
+		}
 	}
 
 	// check if weapon is still in cooldown. If so, can't recover mana
@@ -211,3 +277,9 @@ func (p *Player) Update(dt float32) {
 
 	return
 }
+
+// canStandAt reports whether the player fits at pos: inside the screen and clear of the map's obstacles.
+func (p *Player) canStandAt(pos *Vec2) bool {
+	buffer := p.Width / 4
+	return pos.IsInBounds(GameInstance.ScreenWidth, GameInstance.ScreenHeight, int(buffer)) && !tileMap.Blocks(*pos, buffer)
+}
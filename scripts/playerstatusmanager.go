@@ -1,6 +1,11 @@
 package scripts
 
-import "github.com/hajimehoshi/ebiten/v2"
+import (
+	"fmt"
+	"game/model"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
 
 type StatusBarEnum int
 
@@ -18,34 +23,44 @@ type StatusBarAnimationManager struct {
 	staminaStates []*state
 }
 
-func NewStatusBarAnimationManager(heartSpriteSheet string, manaSpriteSheet string, staminaSpriteSheet string, numHearts rune, numMana rune, numStamina rune) *StatusBarAnimationManager {
-	heartStates := make([]*state, 0, numHearts)
-	for i := 0; i < int(numHearts); i++ {
-		heartStates = append(heartStates, loadDFA(heartSpriteSheet, 0, 0, 5, 32, false))
+func NewStatusBarAnimationManager(heartSpriteSheet string, manaSpriteSheet string, staminaSpriteSheet string, numHearts rune, numMana rune, numStamina rune) (*StatusBarAnimationManager, error) {
+	loadStates := func(spriteSheet string, num rune) ([]*state, error) {
+		states := make([]*state, 0, num)
+		for i := 0; i < int(num); i++ {
+			dfa, err := loadDFA(spriteSheet, 0, 0, model.StatusFrames, model.StatusFrameSize, model.LoopModeHoldLast, 0)
+			if err != nil {
+				return nil, fmt.Errorf("status bar: %w", err)
+			}
+			states = append(states, dfa)
+		}
+		return states, nil
 	}
 
-	manaStates := make([]*state, 0, numMana)
-	for i := 0; i < int(numMana); i++ {
-		manaStates = append(manaStates, loadDFA(manaSpriteSheet, 0, 0, 5, 32, false))
+	heartStates, err := loadStates(heartSpriteSheet, numHearts)
+	if err != nil {
+		return nil, err
 	}
-
-	staminaStates := make([]*state, 0, numStamina)
-	for i := 0; i < int(numStamina); i++ {
-		staminaStates = append(staminaStates, loadDFA(staminaSpriteSheet, 0, 0, 5, 32, false))
+	manaStates, err := loadStates(manaSpriteSheet, numMana)
+	if err != nil {
+		return nil, err
+	}
+	staminaStates, err := loadStates(staminaSpriteSheet, numStamina)
+	if err != nil {
+		return nil, err
 	}
 
 	return &StatusBarAnimationManager{
 		heartStates:   heartStates,
 		manaStates:    manaStates,
 		staminaStates: staminaStates,
-	}
+	}, nil
 }
 
 func (sbam *StatusBarAnimationManager) GetStatusFrames(status StatusBarEnum) []*ebiten.Image {
 	var states []*state = sbam.GetStates(status)
 	frames := make([]*ebiten.Image, 0, len(states))
 	for _, state := range states {
-		frames = append(frames, state.stateData.(*ebiten.Image))
+		frames = append(frames, state.Data.Image)
 	}
 	return frames
 }
@@ -0,0 +1,111 @@
+/*
+This file contains the state machine debugger: an overlay (F3) that labels every animated entity with its
+current state and the input that led there, and a dump (F4) of their state graphs to Graphviz DOT files.
+Render a dump with e.g. `dot -Tsvg fsm_dumps/hero_anim.dot -o hero_anim.svg`.
+*/
+package scripts
+
+import (
+	"fmt"
+	"game/fsm"
+	"os"
+	"path/filepath"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// directory DOT dumps are written to, relative to the working directory
+var fsmDumpDir = "fsm_dumps"
+
+type StateDebugger struct {
+	Overlay bool
+	status  string // result of the last dump
+}
+
+func (sd *StateDebugger) Update(g *Game) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		sd.Overlay = !sd.Overlay
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF4) {
+		if err := sd.Dump(g); err != nil {
+			sd.status = "fsm dump failed: " + err.Error()
+		} else {
+			sd.status = "fsm graphs written to " + fsmDumpDir
+		}
+	}
+}
+
+// Dump writes the hero's animation graph, and the first enemy's animation and AI graphs.
+// The current state of each is highlighted.
+func (sd *StateDebugger) Dump(g *Game) error {
+	if err := os.MkdirAll(fsmDumpDir, 0o755); err != nil {
+		return err
+	}
+	if err := dumpAnimator("hero_anim", heroAnimationManager); err != nil {
+		return err
+	}
+	if len(AllEnemies) > 0 {
+		enemy := AllEnemies[0]
+		if err := dumpAnimator("enemy_anim", enemy.WalkAnimator); err != nil {
+			return err
+		}
+		if err := dumpGraph("enemy_ai", enemy.AI.Current(), enemy.AI.Current()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpAnimator(name string, am *WalkingAnimationManager) error {
+	// every clip is reachable from the base clip
+	return dumpGraph(name, am.base.Current(), am.Machine().Current())
+}
+
+func dumpGraph[T any](name string, start *fsm.State[T], current *fsm.State[T]) error {
+	f, err := os.Create(filepath.Join(fsmDumpDir, name+".dot"))
+	if err != nil {
+		return err
+	}
+	if err := fsm.WriteDOT(f, name, start, current); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (sd *StateDebugger) Draw(screen *ebiten.Image, g *Game) {
+	if sd.status != "" {
+		ebitenutil.DebugPrintAt(screen, sd.status, 10, 110)
+	}
+	if !sd.Overlay {
+		return
+	}
+
+	hero := heroAnimationManager.Machine()
+	drawStateLabel(screen, g.Player.Pos, g.Player.Width, hero.Current().Path(), hero.LastInput())
+
+	for _, enemy := range AllEnemies {
+		if enemy.IsDead() {
+			continue
+		}
+		anim := enemy.WalkAnimator.Machine()
+		label := fmt.Sprintf("ai: %s (%s)", enemy.AI.Current().Path(), inputName(enemy.AI.LastInput()))
+		drawStateLabel(screen, enemy.Pos, enemy.Width, anim.Current().Path(), anim.LastInput())
+		ebitenutil.DebugPrintAt(screen, label, int(enemy.Pos.X-enemy.Width/2), int(enemy.Pos.Y-enemy.Width/2)-32)
+	}
+}
+
+func drawStateLabel(screen *ebiten.Image, pos *Vec2, width float32, state string, input fsm.Input) {
+	x := int(pos.X - width/2)
+	y := int(pos.Y-width/2) - 16
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%s (%s)", state, inputName(input)), x, y)
+}
+
+func inputName(input fsm.Input) string {
+	if input == fsm.Auto {
+		return "auto"
+	}
+	return string(input)
+}
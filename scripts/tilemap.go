@@ -0,0 +1,258 @@
+/*
+This file contains the tile map the arena is drawn from and collides against, loaded from a map made in
+the Tiled editor (see model.ParseTiledMap), or generated from a .gen.json arena description (see
+model.ArenaGen). Tile layers and props (tile objects in object layers) are
+drawn in layer order under the characters, objects of class "spawn" mark where the player and enemies
+start, and collision layers and solid props are obstacles (see obstacle.go). Collision layers are never drawn.
+*/
+package scripts
+
+import (
+	"fmt"
+	"game/model"
+	"image"
+	"io/fs"
+	"log"
+	"math"
+	"math/rand"
+	pathpkg "path"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// the map Init loads, see SetMap
+var mapPath = model.DefaultMap
+
+// seeds generated arenas, 0 picks one at random, see SetMapSeed
+var mapSeed int64
+
+// tileMap is the map being played.
+var tileMap *TileMap
+
+// SetMap plays on the Tiled map (.tmj, .json or .tmx) at path instead of the default arena, or on
+// an arena generated from a .gen.json description.
+func SetMap(path string) {
+	mapPath = path
+}
+
+// SetMapSeed generates the same arena every time, rather than a new one each game.
+func SetMapSeed(seed int64) {
+	mapSeed = seed
+}
+
+type TileMap struct {
+	*model.TiledMap
+	Path      string
+	Obstacles *ObstacleGrid
+	Walk      *model.WalkGrid   // where enemies can path, see Enemy.followPath
+	Flow      *model.FlowField  // toward the player, for hordes, see UpdateFlow
+	Spawns    map[string][]Vec2 // spawn points by name, e.g. "player" and "skeleton", in map order
+	Props     []*MapProp
+
+	layers []*mapLayer
+	images map[uint32]*ebiten.Image // by gid
+}
+
+// MapProp is a tile object placed in an object layer, e.g. a tree or a fence.
+type MapProp struct {
+	Object *model.TiledObject
+	Image  *ebiten.Image
+	Class  string // the object's class, else its tile's, e.g. "tree"
+}
+
+type mapLayer struct {
+	tiles   []*ebiten.Image // row by row, nil where empty
+	props   []*MapProp
+	opacity float32
+}
+
+// LoadTileMap loads a Tiled map and its tilesets, or generates an arena, and cuts every tile it uses.
+func LoadTileMap(path string) (*TileMap, error) {
+	read := func(p string) ([]byte, error) { return fs.ReadFile(assetFS, p) }
+	data, err := read(path)
+	if err != nil {
+		return nil, &AssetError{Path: path, Err: err, Hint: missingHint(path, err)}
+	}
+
+	var def *model.TiledMap
+	if model.IsArenaGen(path) {
+		gen, err := model.ParseArenaGen(data, pathpkg.Dir(path))
+		if err != nil {
+			return nil, &AssetError{Path: path, Err: err}
+		}
+		if mapSeed == 0 {
+			mapSeed = rand.Int63()
+		}
+		// logged so a good arena can be played again with -seed
+		log.Printf("generating %s with seed %d", path, mapSeed)
+		if def, err = gen.Generate(mapSeed, read); err != nil {
+			return nil, &AssetError{Path: path, Err: err}
+		}
+	} else {
+		if def, err = model.ParseTiledMap(data, pathpkg.Dir(path)); err != nil {
+			return nil, &AssetError{Path: path, Err: err}
+		}
+		if err := def.ResolveTilesets(read); err != nil {
+			return nil, &AssetError{Path: path, Err: err, Hint: "tilesets are relative to the map, re-export it next to them"}
+		}
+		if err := def.AutotileLayers(read); err != nil {
+			return nil, &AssetError{Path: path, Err: err}
+		}
+	}
+
+	m := &TileMap{
+		TiledMap:  def,
+		Path:      path,
+		Obstacles: NewObstacleGrid(def.TileWidth*2, def.Colliders()),
+		Walk:      def.WalkGrid(def.TileWidth/2, def.TileWidth/2), // half tiles, clear by a skeleton's footprint
+		Spawns:    make(map[string][]Vec2),
+		images:    make(map[uint32]*ebiten.Image),
+	}
+	m.Flow = model.NewFlowField(m.Walk)
+	for _, layer := range def.Layers {
+		if layer.IsCollision() {
+			continue
+		}
+		ml := &mapLayer{opacity: layer.Opacity}
+		switch layer.Type {
+		case model.TiledTileLayer:
+			ml.tiles = make([]*ebiten.Image, len(layer.Tiles))
+			for i, gid := range layer.Tiles {
+				if ml.tiles[i], err = m.tileImage(gid); err != nil {
+					return nil, fmt.Errorf("%s: layer %q: %w", path, layer.Name, err)
+				}
+			}
+		case model.TiledObjectGroup:
+			for _, obj := range layer.Objects {
+				if err := m.addObject(ml, obj); err != nil {
+					return nil, fmt.Errorf("%s: layer %q: object %d: %w", path, layer.Name, obj.ID, err)
+				}
+			}
+		}
+		if layer.Visible {
+			m.layers = append(m.layers, ml)
+		}
+	}
+	return m, nil
+}
+
+// addObject sorts an object into spawn points and props. Anything else (e.g. plain rectangles) is
+// kept in the Tiled map for scripts to find by name.
+func (m *TileMap) addObject(ml *mapLayer, obj *model.TiledObject) error {
+	class := obj.Class
+	if ts, id := m.TilesetFor(obj.GID); ts != nil && class == "" {
+		if tile, ok := ts.Tiles[id]; ok {
+			class = tile.Class
+		}
+	}
+
+	if class == "spawn" {
+		r := obj.Rect()
+		center := r.Min.Add(r.Max).Div(2)
+		m.Spawns[obj.Name] = append(m.Spawns[obj.Name], Vec2{X: float32(center.X), Y: float32(center.Y)})
+		return nil
+	}
+	if obj.GID == 0 {
+		return nil
+	}
+	img, err := m.tileImage(obj.GID)
+	if err != nil {
+		return err
+	}
+	prop := &MapProp{Object: obj, Image: img, Class: class}
+	ml.props = append(ml.props, prop)
+	m.Props = append(m.Props, prop)
+	return nil
+}
+
+// tileImage returns the image of gid, nil for an empty tile.
+func (m *TileMap) tileImage(gid uint32) (*ebiten.Image, error) {
+	if gid == 0 {
+		return nil, nil
+	}
+	if img, ok := m.images[gid]; ok {
+		return img, nil
+	}
+	ts, id := m.TilesetFor(gid)
+	if ts == nil {
+		return nil, fmt.Errorf("tile %d isn't in any tileset", gid)
+	}
+	path := ts.ImageOf(id)
+	if path == "" {
+		return nil, fmt.Errorf("tile %d of tileset %q has no image", id, ts.Name)
+	}
+
+	var img *ebiten.Image
+	var err error
+	if ts.Image == "" {
+		// image collection, one image per tile
+		img, err = assetManager.Image(path)
+	} else {
+		img, err = assetManager.SubImage(path, ts.TileRect(id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.images[gid] = img
+	return img, nil
+}
+
+// Draw draws the visible layers in order. Tiles larger than the grid, like props, sit on the bottom
+// left of their cell.
+func (m *TileMap) Draw(dst *ebiten.Image) {
+	for _, layer := range m.layers {
+		for i, tile := range layer.tiles {
+			if tile == nil {
+				continue
+			}
+			op := &ebiten.DrawImageOptions{}
+			x := (i % m.Width) * m.TileWidth
+			y := (i/m.Width+1)*m.TileHeight - tile.Bounds().Dy()
+			op.GeoM.Translate(float64(x), float64(y))
+			op.ColorScale.ScaleAlpha(layer.opacity)
+			dst.DrawImage(tile, op)
+		}
+		for _, prop := range layer.props {
+			obj := prop.Object
+			size := prop.Image.Bounds().Size()
+			op := &ebiten.DrawImageOptions{}
+			// objects resized in Tiled stretch their tile
+			if obj.Width > 0 && obj.Height > 0 {
+				op.GeoM.Scale(float64(obj.Width)/float64(size.X), float64(obj.Height)/float64(size.Y))
+			}
+			r := obj.Rect()
+			op.GeoM.Translate(float64(r.Min.X), float64(r.Min.Y))
+			op.ColorScale.ScaleAlpha(layer.opacity)
+			dst.DrawImage(prop.Image, op)
+		}
+	}
+}
+
+// Blocks reports whether a square of half size radius centered on pos overlaps an obstacle. Outside
+// the map is open, the screen edges are checked separately.
+func (m *TileMap) Blocks(pos Vec2, radius float32) bool {
+	return m.Obstacles.At(pos, radius, nil) != nil
+}
+
+// ProjectileHit returns the obstacle a projectile of radius at pos flies into, nil if it flies on.
+func (m *TileMap) ProjectileHit(pos Vec2, radius float32) *Obstacle {
+	return m.Obstacles.At(pos, radius, func(o *Obstacle) bool { return o.BlocksProjectiles })
+}
+
+// cells of the flow field built per tick, a whole arena takes about 10 ticks
+const flowFieldBudget = 500
+
+// UpdateFlow moves the flow field's goal to target, rebuilding the whole field a budget of cells per call.
+func (m *TileMap) UpdateFlow(target Vec2) {
+	m.Flow.Retarget(m.Walk.CellAt(image.Point{X: int(math.Floor(float64(target.X))), Y: int(math.Floor(float64(target.Y)))}))
+	m.Flow.Step(flowFieldBudget)
+}
+
+// SpawnPoint returns the i'th spawn point called name, wrapping around when there are fewer.
+func (m *TileMap) SpawnPoint(name string, i int) (Vec2, bool) {
+	points := m.Spawns[name]
+	if len(points) == 0 {
+		return Vec2{}, false
+	}
+	return points[i%len(points)], true
+}
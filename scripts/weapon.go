@@ -8,7 +8,8 @@ type Projectile struct {
 	Speed  float32
 	Radius float32
 	Gas    float32 // how far can it has left to travel
-
+	// impulse given to what it hits, px/s for a mass of 1
+	Knockback float32
 }
 
 type Weapon struct {
@@ -18,4 +19,7 @@ type Weapon struct {
 	ProjectileInstance *Projectile
 	LastDir            *Vec2 // remembers last fire direction if aiming is zero
 	ParticleEmitter    *SmokeEmitter
+	CastClip           string  // hero animation played when it fires, e.g. "spellcast" or "shoot"
+	charged            bool    // fired, the projectile leaves on the clip's "cast" frame
+	chargeTimer        float32 // seconds since it was fired, see castReleaseSec
 }